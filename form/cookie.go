@@ -0,0 +1,64 @@
+package form
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SignCookieValue signs the value of the cookie name with the HMAC-SHA256 key,
+// it returns the value followed by a dot and the unpadded base64url signature,
+// which is verified by the Decoder of the same CookieKey for the fields tagged with the `signed` option.
+func SignCookieValue(key []byte, name, value string) string {
+	return value + "." + cookieSignature(key, name, value)
+}
+
+// cookieSignature signs the name as well, so a signed value can't be replayed as another cookie.
+func cookieSignature(key []byte, name, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "=" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var errInvalidSignature = errors.New("invalid cookie signature")
+
+// verifyCookieValue returns the value of the signed cookie with the signature stripped.
+func verifyCookieValue(key []byte, name, signed string) (string, error) {
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 {
+		return "", errInvalidSignature
+	}
+	value, sig := signed[:i], signed[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(cookieSignature(key, name, value))) {
+		return "", errInvalidSignature
+	}
+	return value, nil
+}
+
+// unpackCookie populates the field tagged with `cookie` from the request cookie of its name if any.
+func (d *Decoder) unpackCookie(r *http.Request, f field) error {
+	c, err := r.Cookie(f.name)
+	if err != nil {
+		return nil // absent
+	}
+	value := c.Value
+	if f.opts.Has("signed") {
+		if len(d.CookieKey) == 0 {
+			return fmt.Errorf("%s: no cookie key to verify the signature", f.name)
+		}
+		if value, err = verifyCookieValue(d.CookieKey, f.name, value); err != nil {
+			if d.SkipInvalidCookies {
+				return nil
+			}
+			return fieldError(f.name, err)
+		}
+	}
+	if err := d.populate(f.v, value, &f); err != nil {
+		return fieldError(f.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package form_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/longkai/encoding/form"
+)
+
+func TestUnpackSignedCookie(t *testing.T) {
+	type Params struct {
+		Theme string `cookie:"theme"`
+		Prefs string `cookie:"prefs,signed"`
+		Q     string `json:"q"`
+	}
+	key := []byte("s3cr3t")
+	signed := form.SignCookieValue(key, "prefs", "dark,compact")
+	tampered := "light" + signed[len("dark"):] // the signature of dark,compact
+	testCases := []struct {
+		desc    string
+		cookies []*http.Cookie
+		skip    bool
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc:    "valid signature",
+			cookies: []*http.Cookie{{Name: "theme", Value: "blue"}, {Name: "prefs", Value: signed}},
+			want:    Params{Theme: "blue", Prefs: "dark,compact", Q: "golang"},
+		},
+		{
+			desc:    "absent",
+			cookies: nil,
+			want:    Params{Q: "golang"},
+		},
+		{
+			desc:    "tampered",
+			cookies: []*http.Cookie{{Name: "prefs", Value: tampered}},
+			wantErr: true,
+		},
+		{
+			desc:    "unsigned",
+			cookies: []*http.Cookie{{Name: "prefs", Value: "dark,compact"}},
+			wantErr: true,
+		},
+		{
+			desc:    "replayed as another cookie",
+			cookies: []*http.Cookie{{Name: "prefs", Value: form.SignCookieValue(key, "session", "dark,compact")}},
+			wantErr: true,
+		},
+		{
+			desc:    "tampered skipped",
+			cookies: []*http.Cookie{{Name: "theme", Value: "blue"}, {Name: "prefs", Value: tampered}},
+			skip:    true,
+			want:    Params{Theme: "blue", Q: "golang"},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?q=golang", nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			for _, cookie := range c.cookies {
+				req.AddCookie(cookie)
+			}
+			d := form.Decoder{CookieKey: key, SkipInvalidCookies: c.skip}
+			var params Params
+			if err := d.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack err = %v, want error %t", err, c.wantErr)
+				return
+			}
+			if !c.wantErr && params != c.want {
+				t.Errorf("Unpack got %+v, want %+v", params, c.want)
+			}
+		})
+	}
+}
@@ -2,12 +2,32 @@
 package form
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // Option pack option.
@@ -19,24 +39,215 @@ const (
 	// Query only parses the request URL query.
 	Query
 	// Multipart like Body but counts multipart files in.
+	// The text parts are bound before the file parts, so a file part and its metadata parts,
+	// e.g., `chunk` and `chunk_offset`, can be bound into sibling fields in one pass.
 	Multipart
-	// Mixed mixes the request body and URL query, note the Query has higher priority if same key found.
+	// Mixed mixes the request body and URL query, note the Query has higher priority if same key found,
+	// and the values of a slice field are the query ones followed by the body ones.
 	// It's existed for compatability only.
 	Mixed
-	// MixedMultipart mixes Multipart and Query, Query has higher priority.
+	// MixedMultipart mixes Multipart and Query, Query has higher priority if same key found as Mixed,
+	// i.e., for the text parts, the values of a slice field are the query ones followed by the multipart ones.
 	// It's existed for compatability only.
 	MixedMultipart
+	// MultipartStream like Multipart but reads the parts one by one as they stream in,
+	// rather than buffering the whole form in memory or temporary files.
+	// A file part is bound to the file field of its name as Multipart, stored in memory or a temporary file
+	// as it streams in, see MultipartMaxMemory, otherwise it's bound as a form value like the text parts.
+	// Each part is bounded by Decoder.MaxPartSize.
+	MultipartStream
 )
 
 // MultipartMaxMemory the up to a total of maxMemory bytes of its file parts are stored in memory.
 // See http.Request.ParseMultipartForm for more information.
-// Default to 10M.
-var MultipartMaxMemory int64 = 10 * 1024
+// Default to 10MB like the http package.
+var MultipartMaxMemory int64 = 10 * 1024 * 1024
+
+// MaxFileSize caps the size of each uploaded file of the Multipart options, zero means unlimited.
+// Unlike MultipartMaxMemory, it rejects the request rather than spilling the file to disk.
+var MaxFileSize int64
+
+// MaxTotalUploadSize caps the total size of the uploaded files of the Multipart options, zero means unlimited.
+var MaxTotalUploadSize int64
 
 // FieldTag is the default tag key.
+// It's shared by all the calls, see UnpackWithOptions or Decoder.Tag for the tag key of a call.
 var FieldTag = "json"
 
-var fileHeaderPtrType = reflect.TypeOf(&multipart.FileHeader{})
+var (
+	fileHeaderPtrType = reflect.TypeOf(&multipart.FileHeader{})
+	fileType          = reflect.TypeOf((*multipart.File)(nil)).Elem()
+	bytesType         = reflect.TypeOf([]byte(nil))
+	timeType          = reflect.TypeOf(time.Time{})
+	ratType           = reflect.TypeOf(big.Rat{})
+	numberType        = reflect.TypeOf(json.Number(""))
+)
+
+// IntBool is the policy of parsing an integer as bool.
+type IntBool int
+
+const (
+	// IntBoolStrict only accepts 0 and 1, rejects the other integers.
+	IntBoolStrict IntBool = iota
+	// IntBoolLenient treats any nonzero integer as true.
+	IntBoolLenient
+)
+
+// EmptyElementPolicy is the policy of the empty elements of a slice field, e.g., `ids=&ids=5`.
+type EmptyElementPolicy int
+
+const (
+	// EmptyElementKeep keeps the empty elements,
+	// it errors only if the element type can't be parsed from the empty string.
+	EmptyElementKeep EmptyElementPolicy = iota
+	// EmptyElementDrop skips the empty elements.
+	EmptyElementDrop
+	// EmptyElementError rejects the request with any empty element.
+	EmptyElementError
+)
+
+// Decoder decodes HTTP request parameters into Golang struct.
+// The zero value is ready to use, which falls back to the package variables, e.g., FieldTag and MultipartMaxMemory,
+// as the package functions do. A Decoder is safe for concurrent use as long as its fields are not modified.
+type Decoder struct {
+	// Tag is the tag key of the fields, empty means FieldTag.
+	Tag string
+	// AtomicSlices binds slice fields all or nothing,
+	// i.e., a slice field is left untouched if any of its elements fails to parse.
+	AtomicSlices bool
+	// MaxSliceLen caps the number of elements appended to a slice field, zero means unlimited,
+	// so does the index of the explicitly indexed keys, e.g., `ids[99]` is the last one of 100.
+	// It can be overridden per field by the tag option, e.g., `json:"ids,max=100"`.
+	MaxSliceLen int
+	// TruncateSlices truncates the exceeded elements instead of returning an error.
+	TruncateSlices bool
+	// Validators are custom checks keyed by field name, e.g., `address.city`.
+	// They are invoked after all the fields are populated and the defaults applied, so a preset value
+	// of an absent field is validated as well, and only if the required fields are present,
+	// then followed by the Validate method of the struct. The failures are aggregated into a MultiError.
+	// Only the fields bound from the form can be validated, a validator of the other fields,
+	// e.g., of the `header` or `cookie` tag, or the map fields, fails with the unknown field error.
+	Validators map[string]func(fieldName string, v reflect.Value) error
+	// LiteralPlus keeps '+' in the application/x-www-form-urlencoded body as is
+	// rather than decoding it as space, for the clients sending already-decoded bodies.
+	// Note the URL query is not affected.
+	LiteralPlus bool
+	// MaxSliceIndex is the max index of the explicitly indexed slice key, e.g., `items[1]=x`,
+	// which guards against huge allocation forced by a sparse high index.
+	// Zero means the default 1000.
+	MaxSliceIndex int
+	// MaxFormFields caps the number of the form values of the URL query,
+	// application/x-www-form-urlencoded and multipart/form-data body (file parts not counted),
+	// zero means unlimited.
+	MaxFormFields int
+	// IntBool is the policy of parsing an integer as bool, e.g., `active=2`.
+	// It can be overridden per field by the tag option, e.g., `json:"active,intbool=lenient"`.
+	IntBool IntBool
+	// TrueValues and FalseValues are the extra strings of true and false respectively for the bool fields,
+	// matched case-insensitively before the built-in ones, e.g., Y and N.
+	TrueValues  []string
+	FalseValues []string
+	// EmptyArrayElement is the policy of the empty elements of slice fields.
+	// Note the tag option `skipempty` always drops the empty elements of the field.
+	EmptyArrayElement EmptyElementPolicy
+	// MultipartJSON decodes the multipart part named `json`, which carries a JSON object,
+	// into the struct first, then binds the other parts on top.
+	MultipartJSON bool
+	// OnBind is called with the populated struct after a successful bind,
+	// i.e., after all the fields are populated and validated, e.g., for logging or metrics.
+	OnBind func(r *http.Request, ptr interface{})
+	// ZeroBeforeBind resets the struct to its zero value before populating,
+	// so that the stale values of a reused struct, e.g., from a sync.Pool, won't leak.
+	ZeroBeforeBind bool
+	// PreserveNonZero populates only the fields of zero value before binding,
+	// so the presets win over the request values, e.g., for the defaults which can't be overridden.
+	PreserveNonZero bool
+	// StrictSingleFile fails the bind if a non-slice file field receives multiple file parts,
+	// rather than keeping the last one silently.
+	StrictSingleFile bool
+	// CookieKey is the HMAC key verifying the cookies tagged with the `signed` option,
+	// e.g., `cookie:"prefs,signed"`, see SignCookieValue.
+	CookieKey []byte
+	// SkipInvalidCookies leaves the field of a signed cookie with an invalid signature untouched,
+	// rather than failing the bind.
+	SkipInvalidCookies bool
+	// FieldTiming is called with the time elapsed to populate each value of a field for profiling,
+	// e.g., the expensive time parsing or custom factories, a slice field reports for each element.
+	// It costs nothing if nil.
+	FieldTiming func(name string, elapsed time.Duration)
+	// MaxPartSize limits the bytes of each part of the MultipartStream option as it's read,
+	// so a single huge part is rejected before exhausting the memory. Zero means no limit.
+	MaxPartSize int64
+	// NormalizeNFC normalizes the string values into the Unicode NFC form,
+	// so that the composed and decomposed inputs, e.g., é and e followed by U+0301, compare equal.
+	// It can be enabled per field by the tag option, e.g., `json:"username,nfc"`.
+	NormalizeNFC bool
+	// CaseInsensitive matches the form keys to the fields case-insensitively, e.g., UserName, username and USERNAME,
+	// the values of the keys differ only by case are merged in the order of the keys.
+	// It fails if two fields differ only by case. Note the map fields of a key prefix are matched as is.
+	CaseInsensitive bool
+	// SkipValidate skips calling the Validate method of the struct implementing Validator after binding,
+	// e.g., for the callers validating elsewhere.
+	SkipValidate bool
+	// MaxMemory is the max bytes of the file parts stored in memory of the Multipart options,
+	// zero means MultipartMaxMemory.
+	MaxMemory int64
+	// MaxFileSize and MaxTotalUploadSize cap the size of each uploaded file and the total of them respectively,
+	// zero means the package ones of the same names.
+	MaxFileSize        int64
+	MaxTotalUploadSize int64
+	// CollectErrors binds all the form values and file parts rather than stopping at the first failure,
+	// the errors of the fields are aggregated into a MultiError in the order of name.
+	CollectErrors bool
+}
+
+// Validator is implemented by the struct validating itself, e.g., the range of a field.
+// The Validate method is called after all the fields are populated and checked, unless Decoder.SkipValidate.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError is the error of a field failed to bind, e.g., for a 400 response per field.
+type FieldError struct {
+	// Field is the request key of the field, e.g., `ids`, `items[1]` or `prefs` of a cookie.
+	Field string
+	// Value is the offending value if any, it's redacted for the field of the `secret` option.
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Err.Error()
+	}
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns err of the field as a FieldError, completing the one returned by populate with the name.
+func fieldError(name string, err error) error {
+	if fe, ok := err.(*FieldError); ok && fe.Field == "" {
+		fe.Field = name
+		return fe
+	}
+	return &FieldError{Field: name, Err: err}
+}
+
+// MultiError is a list of errors occurred while unpacking.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var defaultDecoder Decoder
 
 // Unpack populates the fields of the struct pointed to by ptr
 // from the HTTP request body in r.
@@ -47,132 +258,1961 @@ func Unpack(r *http.Request, ptr interface{}) error {
 // UnpackWithOption populates the fields of the struct pointed to by ptr
 // from the HTTP request parameters in r with the given unpack option.
 func UnpackWithOption(r *http.Request, ptr interface{}, option Option) error {
-	var err error
+	return defaultDecoder.Unpack(r, ptr, option)
+}
+
+// Options is the options of an unpack call.
+type Options struct {
+	// Tag is the tag key, empty means FieldTag.
+	Tag string
+	// Option is the unpack option.
+	Option Option
+}
+
+// UnpackWithOptions populates the fields of the struct pointed to by ptr
+// from the HTTP request parameters in r with the given options,
+// e.g., the tag key for the call only, rather than changing FieldTag which is shared by all calls.
+func UnpackWithOptions(r *http.Request, ptr interface{}, opts Options) error {
+	d := defaultDecoder
+	d.Tag = opts.Tag
+	return d.Unpack(r, ptr, opts.Option)
+}
+
+// Unpack populates the fields of the struct pointed to by ptr
+// from the HTTP request parameters in r with the given unpack option.
+func (d *Decoder) Unpack(r *http.Request, ptr interface{}, option Option) error {
+	_, err := d.bind(r, ptr, option)
+	return err
+}
+
+// UnpackWithReport is like UnpackWithOption, however, it reports the names of the fields present in the request,
+// e.g., for a PATCH handler to update the columns sent only, see Decoder.UnpackWithReport.
+func UnpackWithReport(r *http.Request, ptr interface{}, option Option) (map[string]bool, error) {
+	return defaultDecoder.UnpackWithReport(r, ptr, option)
+}
+
+// UnpackWithReport is like Unpack, however, it reports the names of the fields present in the request and populated,
+// keyed by effective name, e.g., `address.city`, or the name of a map field, e.g., `meta` or `filter_*`.
+// The ones of the defaults or the sources other than the form are excluded, e.g., the `default` option or the `header` tag.
+func (d *Decoder) UnpackWithReport(r *http.Request, ptr interface{}, option Option) (map[string]bool, error) {
+	fs, err := d.bind(r, ptr, option)
+	if err != nil {
+		return nil, err
+	}
+	report := make(map[string]bool, len(fs.seen))
+	for name := range fs.seen {
+		if f, ok := fs.named[name]; ok {
+			name = f.name
+		}
+		report[name] = true // the map fields are keyed by their names already
+	}
+	return report, nil
+}
+
+// bind populates the fields of the struct pointed to by ptr from r, it returns the fields bound.
+func (d *Decoder) bind(r *http.Request, ptr interface{}, option Option) (*fieldSet, error) {
+	fs, err := d.fieldSet(ptr)
+	if err != nil {
+		return nil, err
+	}
+	// Capture the raw sources before parsing the form which consumes the body.
+	if err := d.unpackSourced(r, fs.sourced); err != nil {
+		return nil, err
+	}
+
+	var streamed url.Values
 	if option == Multipart || option == MixedMultipart {
-		err = r.ParseMultipartForm(MultipartMaxMemory)
+		err = r.ParseMultipartForm(d.maxMemory())
+	} else if option == MultipartStream {
+		streamed, err = d.readMultipartStream(r, fs)
 	} else { // Otherwise treat all as application/x-www-form-urlencoded type.
-		err = r.ParseForm()
+		if d.LiteralPlus && option != Query {
+			err = parsePostFormLiteralPlus(r)
+		}
+		if err == nil {
+			err = r.ParseForm()
+		}
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// Build map of fields keyed by effective name.
-	fields := make(map[string]reflect.Value)
-	v := reflect.ValueOf(ptr).Elem() // the struct variable
-	for i := 0; i < v.NumField(); i++ {
-		fieldInfo := v.Type().Field(i) // a reflect.StructField
-		tag := fieldInfo.Tag           // a reflect.StructTag
-		name := tag.Get(FieldTag)
-		if name == "" {
-			// First letter to lower since most languages will style that way.
-			for i := range fieldInfo.Name {
-				name = strings.ToLower(fieldInfo.Name[:i+1]) + fieldInfo.Name[i+1:]
-				break
-			}
+	if d.MultipartJSON && (option == Multipart || option == MixedMultipart) {
+		if err := unpackJSONPart(r.MultipartForm, ptr); err != nil {
+			return nil, err
 		}
-		fields[name] = v.Field(i)
 	}
 
 	switch option {
 	case Query:
-		return unpack(fields, r.URL.Query())
+		err = d.unpack(fs, r.URL.Query())
 	default:
 		fallthrough
 	case Body:
-		return unpack(fields, r.PostForm)
+		err = d.unpack(fs, r.PostForm)
 	case Mixed:
-		return unpack(fields, r.Form)
+		err = d.unpack(fs, d.mergeValues(fs, r.URL.Query(), r.PostForm))
 	case Multipart:
-		err = unpack(fields, r.PostForm)
+		err = d.unpack(fs, multipartValues(r))
 	case MixedMultipart:
-		err = unpack(fields, r.Form)
+		err = d.unpack(fs, d.mergeValues(fs, r.URL.Query(), multipartValues(r)))
+	case MultipartStream:
+		err = d.unpack(fs, streamed)
+	}
+	var errs MultiError // of the fields collected, see Decoder.CollectErrors
+	if err := d.collectAll(&errs, err); err != nil {
+		return nil, err
 	}
-	// Contine handle parsing multipart.
+	if option == Multipart || option == MixedMultipart || option == MultipartStream {
+		// Contine handle parsing multipart.
+		if err := d.collectAll(&errs, d.unpackMultipart(fs, r.MultipartForm.File)); err != nil {
+			return nil, err
+		}
+	}
+	if err := collected(errs); err != nil {
+		return nil, err
+	}
+	if option == Multipart || option == MixedMultipart || option == MultipartStream {
+		if err := d.unpackPartHeaders(fs.sourced, r.MultipartForm.File); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.complete(fs, r.Method); err != nil {
+		return nil, err
+	}
+	if err := d.validateStruct(ptr); err != nil {
+		return nil, err
+	}
+	if d.OnBind != nil {
+		d.OnBind(r, ptr)
+	}
+	return fs, nil
+}
+
+// UnpackValues populates the fields of the struct pointed to by ptr from the values directly,
+// e.g., of a stored query string or a websocket message, with the tag key, empty means FieldTag.
+func UnpackValues(values url.Values, ptr interface{}, tag string) error {
+	d := defaultDecoder
+	d.Tag = tag
+	return d.UnpackValues(values, ptr)
+}
+
+// UnpackValues populates the fields of the struct pointed to by ptr from the values directly
+// as the form of a request, however, the fields bound from the other sources of the request are left untouched,
+// e.g., the `cookie` or `header` tag, so are the `requiredfor` option and the OnBind callback.
+func (d *Decoder) UnpackValues(values url.Values, ptr interface{}) error {
+	fs, err := d.fieldSet(ptr)
 	if err != nil {
 		return err
 	}
-	return unpackMultipart(fields, r.MultipartForm.File)
+	if err := d.unpack(fs, values); err != nil {
+		return err
+	}
+	if err := d.complete(fs, ""); err != nil {
+		return err
+	}
+	return d.validateStruct(ptr)
 }
 
-func unpack(fields map[string]reflect.Value, form map[string][]string) error {
-	// Update struct field for each parameter in the request.
-	for name, values := range form {
-		f := fields[name]
-		if !f.IsValid() {
-			continue // ignore unrecognized HTTP parameters
+// fieldSet builds the fields of the struct pointed to by ptr keyed by effective name.
+func (d *Decoder) fieldSet(ptr interface{}) (*fieldSet, error) {
+	v := reflect.ValueOf(ptr).Elem() // the struct variable
+	if d.ZeroBeforeBind {
+		v.Set(reflect.Zero(v.Type()))
+	}
+	return d.fieldsOf(v)
+}
+
+// fieldsOf builds the fields of the struct v keyed by effective name.
+func (d *Decoder) fieldsOf(v reflect.Value) (*fieldSet, error) {
+	fs, err := cachedFields(v.Type(), d.tag())
+	if err != nil {
+		return nil, err
+	}
+	fs = fs.bind(v)
+	if d.PreserveNonZero {
+		fs.dropNonZero()
+	}
+	if d.CaseInsensitive {
+		if err := fs.foldNames(); err != nil {
+			return nil, err
 		}
-		for _, value := range values {
-			if f.Kind() == reflect.Slice {
-				elem := reflect.New(f.Type().Elem()).Elem()
-				if err := populate(elem, value); err != nil {
-					return fmt.Errorf("%s: %v", name, err)
-				}
-				f.Set(reflect.Append(f, elem))
-			} else {
-				if err := populate(f, value); err != nil {
-					return fmt.Errorf("%s: %v", name, err)
-				}
-			}
+	}
+	return fs, nil
+}
+
+// complete applies the defaults, then checks the required fields of the request method and validates the fields.
+func (d *Decoder) complete(fs *fieldSet, method string) error {
+	if err := d.unpackDefaults(fs); err != nil {
+		return err
+	}
+	if err := checkRequired(fs, method); err != nil {
+		return err
+	}
+	return d.validate(fs.named)
+}
+
+// multipartValues returns the text values of the multipart form of r, including those in r.PostForm only.
+// The r.PostForm lacks them if r.MultipartForm was parsed by others, e.g., a middleware.
+func multipartValues(r *http.Request) url.Values {
+	values := make(url.Values, len(r.MultipartForm.Value))
+	for key, vs := range r.MultipartForm.Value {
+		values[key] = vs
+	}
+	for key, vs := range r.PostForm {
+		if _, ok := values[key]; !ok {
+			values[key] = vs
 		}
 	}
-	return nil
+	return values
 }
 
-func unpackMultipart(fields map[string]reflect.Value, m map[string][]*multipart.FileHeader) error {
-	for name, parts := range m {
-		f := fields[name]
-		if !f.IsValid() {
-			continue // ignore unrecognized HTTP parameters
+// mergeValues merges the URL query and body values of the Mixed options in a defined order
+// rather than relying on that of http.Request.Form: the query values precede the body ones for the slice fields,
+// otherwise they follow, so the query has higher priority for the scalar fields.
+// The keys differ only by case are folded within each source first, so the rule holds across them.
+func (d *Decoder) mergeValues(fs *fieldSet, query, body url.Values) url.Values {
+	if d.CaseInsensitive {
+		query, body = foldValues(fs, query), foldValues(fs, body)
+	}
+	merged := make(url.Values, len(query)+len(body))
+	for name, values := range body {
+		merged[name] = append(merged[name], values...)
+	}
+	for name, values := range query {
+		if f, ok := fs.named[d.foldName(name)]; ok && isSlice(f.v.Type()) {
+			merged[name] = append(append([]string(nil), values...), merged[name]...)
+			continue
 		}
-		for _, part := range parts {
-			if f.Kind() == reflect.Slice {
-				elem := reflect.New(f.Type().Elem()).Elem()
-				if err := populatePart(elem, part); err != nil {
-					return fmt.Errorf("%s: %v", name, err)
-				}
-				f.Set(reflect.Append(f, elem))
-			} else {
-				if err := populatePart(f, part); err != nil {
-					return fmt.Errorf("%s: %v", name, err)
-				}
+		merged[name] = append(merged[name], values...)
+	}
+	return merged
+}
+
+// readMultipartStream reads the multipart body of r part by part into the form values,
+// except the file parts of the fields other than the text ones, which are stored as r.MultipartForm.File,
+// so they are removed by the server after the request as those of Multipart.
+func (d *Decoder) readMultipartStream(r *http.Request, fs *fieldSet) (url.Values, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	values := make(url.Values)
+	form := &multipart.Form{Value: values, File: make(map[string][]*multipart.FileHeader)}
+	r.MultipartForm = form
+	memory := d.maxMemory() // left for the files in memory
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var pr io.Reader = p
+		if d.MaxPartSize > 0 {
+			pr = &partLimitReader{r: p, n: d.MaxPartSize}
+		}
+		name := p.FormName()
+		if f, ok := fs.lookup(d.foldName(name)); name != "" && p.FileName() != "" && (!ok || isFileField(f)) {
+			part, err := storePart(p, pr, memory)
+			p.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
 			}
+			memory -= part.Size
+			form.File[name] = append(form.File[name], part)
+			continue
+		}
+		b, err := ioutil.ReadAll(pr)
+		p.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
 		}
+		values.Add(name, string(b))
 	}
-	return nil
 }
 
-func populatePart(v reflect.Value, part *multipart.FileHeader) error {
-	if fileHeaderPtrType != v.Type() {
-		return fmt.Errorf("unsupported multipart kind %s", v.Kind())
+// isFileField reports whether the field, or its element of a slice, is populated from a file part.
+func isFileField(f field) bool {
+	t := f.v.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
 	}
-	v.Set(reflect.ValueOf(part))
-	return nil
+	return t == fileHeaderPtrType || t.Kind() == reflect.Interface && t.NumMethod() > 0 && fileType.Implements(t)
 }
 
-func populate(v reflect.Value, value string) error {
-	switch v.Kind() {
-	case reflect.String:
-		v.SetString(value)
-	case reflect.Int:
-		i, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			return err
+// storePart stores the file part read from r in memory up to maxMemory bytes, otherwise in a temporary file.
+// The part is piped through a multipart form of its own, since the file header can't be made otherwise.
+func storePart(p *multipart.Part, r io.Reader, maxMemory int64) (*multipart.FileHeader, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		w, err := mw.CreatePart(p.Header)
+		if err == nil {
+			_, err = io.Copy(w, r)
 		}
-		v.SetInt(i)
-	case reflect.Bool:
-		b, err := strconv.ParseBool(value)
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	if maxMemory < 0 {
+		maxMemory = 0
+	}
+	form, err := multipart.NewReader(pr, mw.Boundary()).ReadForm(maxMemory)
+	pr.Close() // unblocks the writer if the form is abandoned
+	if err != nil {
+		return nil, err
+	}
+	parts := form.File[p.FormName()]
+	if len(parts) == 0 {
+		form.RemoveAll()
+		return nil, errors.New("multipart: no file part")
+	}
+	return parts[0], nil
+}
+
+var errPartTooLarge = errors.New("multipart: part too large")
+
+// partLimitReader is like io.LimitReader but fails with errPartTooLarge on overflow
+// instead of truncating the part silently.
+type partLimitReader struct {
+	r io.Reader
+	n int64 // bytes remaining
+}
+
+func (l *partLimitReader) Read(p []byte) (int, error) {
+	// Read one more byte than allowed to detect the overflow.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errPartTooLarge
+	}
+	return n, err
+}
+
+// jsonPart is the name of the multipart part carrying a JSON object of the struct.
+const jsonPart = "json"
+
+// unpackJSONPart decodes the JSON part of the multipart form into ptr if any.
+func unpackJSONPart(m *multipart.Form, ptr interface{}) error {
+	var b []byte
+	if values := m.Value[jsonPart]; len(values) > 0 {
+		b = []byte(values[0])
+	} else if parts := m.File[jsonPart]; len(parts) > 0 {
+		// Some clients upload the JSON as a blob.
+		f, err := parts[0].Open()
 		if err != nil {
 			return err
 		}
-		v.SetBool(b)
-	case reflect.Float64:
-		f, err := strconv.ParseFloat(value, 64)
-		if err != nil {
+		defer f.Close()
+		if b, err = ioutil.ReadAll(f); err != nil {
 			return err
 		}
-		v.SetFloat(f)
-	default:
-		return fmt.Errorf("unsupported kind %s", v.Type())
+	} else {
+		return nil
+	}
+	if err := json.Unmarshal(b, ptr); err != nil {
+		return fmt.Errorf("%s: %v", jsonPart, err)
+	}
+	return nil
+}
+
+// maxFormSize is the body size limit of http.Request.ParseForm.
+const maxFormSize = 10 << 20
+
+// parsePostFormLiteralPlus parses the application/x-www-form-urlencoded body like http.Request.ParseForm,
+// but keeps '+' as is rather than decoding it as space.
+func parsePostFormLiteralPlus(r *http.Request) error {
+	if r.PostForm != nil || r.Body == nil {
+		return nil
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		return nil
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "application/x-www-form-urlencoded" {
+		return nil // Leave it to http.Request.ParseForm.
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxFormSize+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > maxFormSize {
+		return errors.New("http: POST too large")
+	}
+	vs, err := url.ParseQuery(strings.Replace(string(b), "+", "%2B", -1))
+	if err != nil {
+		return err
+	}
+	// The r.Form will be merged with the URL query by http.Request.ParseForm later.
+	r.PostForm = vs
+	return nil
+}
+
+// validateStruct calls the Validate method of ptr if it implements Validator.
+func (d *Decoder) validateStruct(ptr interface{}) error {
+	if v, ok := ptr.(Validator); ok && !d.SkipValidate {
+		return v.Validate()
+	}
+	return nil
+}
+
+// validate runs the registered validators, the failures are aggregated into a MultiError.
+func (d *Decoder) validate(fields map[string]field) error {
+	names := make([]string, 0, len(d.Validators))
+	for name := range d.Validators {
+		names = append(names, name)
+	}
+	sort.Strings(names) // for stable error order
+	var errs MultiError
+	for _, name := range names {
+		f, ok := fields[d.foldName(name)]
+		if !ok {
+			return fmt.Errorf("validator for unknown field %s", name)
+		}
+		if err := d.Validators[name](name, f.v); err != nil {
+			errs = append(errs, fieldError(name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// fieldSet is the fields of a struct to be populated.
+type fieldSet struct {
+	named    map[string]field           // bound from the form, keyed by effective name
+	prefixed []field                    // map fields bound from the form keys of a prefix, e.g., `filter_*`, `meta[` or `meta.`
+	sourced  []field                    // bound from other sources of the request, e.g., the raw query
+	seen     map[string]bool            // names of the named and map fields present in the request
+	groups   map[string]map[string]bool // names seen of the struct elements keyed by the indexed key, e.g., items[1]
+}
+
+func newFieldSet() *fieldSet {
+	return &fieldSet{named: make(map[string]field), seen: make(map[string]bool)}
+}
+
+// foldNames rekeys the named fields by their lower case names for the case-insensitive matching,
+// it fails if two fields differ only by case, e.g., `userName` and `username`.
+func (fs *fieldSet) foldNames() error {
+	named := make(map[string]field, len(fs.named))
+	for name, f := range fs.named {
+		folded := strings.ToLower(name)
+		if other, ok := named[folded]; ok {
+			return fmt.Errorf("fields %s and %s collide case-insensitively", other.name, f.name)
+		}
+		named[folded] = f
+	}
+	fs.named = named
+	return nil
+}
+
+// lookup returns the named field to be populated, i.e., not preserved.
+func (fs *fieldSet) lookup(name string) (field, bool) {
+	f, ok := fs.named[name]
+	return f, ok && !f.preserved
+}
+
+// foldName returns the key of the named fields for the form key name.
+func (d *Decoder) foldName(name string) string {
+	if d.CaseInsensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// foldValues merges the values of the form keys matching the same named field case-insensitively,
+// in the order of the keys for the determinism, e.g., `UserName` and `username`.
+// The other keys are left as is, e.g., of the map fields.
+func foldValues(fs *fieldSet, form map[string][]string) map[string][]string {
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	folded := make(map[string][]string, len(form))
+	for _, key := range keys {
+		name := key
+		if _, ok := fs.named[strings.ToLower(key)]; ok {
+			name = strings.ToLower(key)
+		}
+		folded[name] = append(folded[name], form[key]...)
+	}
+	return folded
+}
+
+// dropNonZero drops the fields which are not zero, e.g., the presets, so they are not populated.
+// The named ones are marked as preserved rather than dropped, so they are still validated.
+func (fs *fieldSet) dropNonZero() {
+	for name, f := range fs.named {
+		if !f.v.IsZero() {
+			f.preserved = true
+			fs.named[name] = f
+		}
+	}
+	fs.prefixed = zeroFields(fs.prefixed)
+	fs.sourced = zeroFields(fs.sourced)
+}
+
+func zeroFields(fields []field) []field {
+	zero := fields[:0]
+	for _, f := range fields {
+		if f.v.IsZero() {
+			zero = append(zero, f)
+		}
+	}
+	return zero
+}
+
+// unpackDefaults populates the fields absent in the request from their `default` option,
+// e.g., `json:"page,default=1"`, the value of a slice field is split by comma, e.g., `json:"tags,default=a,b"`.
+// The defaults are applied before checking the required fields,
+// so a required field is satisfied by its default only with the `allowdefault` option.
+func (d *Decoder) unpackDefaults(fs *fieldSet) error {
+	for name, f := range fs.named {
+		def, ok := f.opts["default"]
+		if !ok || fs.seen[name] || f.preserved {
+			continue
+		}
+		values := []string{def}
+		if isSlice(f.v.Type()) {
+			values = strings.Split(def, ",")
+		}
+		if err := d.unpackField(f, values); err != nil {
+			return fieldError(name, fmt.Errorf("default %q: %w", def, err))
+		}
+	}
+	return nil
+}
+
+// checkRequired returns a MultiError of the required fields absent in the request,
+// e.g., `json:"q,required"`, or `json:"version,requiredfor=PUT,PATCH"` which is required for PUT and PATCH only.
+// A field is present if its key appears in the request, even with an empty value,
+// while a preset value of an absent field doesn't count, unless the `allowdefault` option is set,
+// e.g., `json:"page,required,allowdefault"`.
+func checkRequired(fs *fieldSet, method string) error {
+	names := make([]string, 0, len(fs.named))
+	for name := range fs.named {
+		names = append(names, name)
+	}
+	sort.Strings(names) // for stable error order
+	var errs MultiError
+	for _, name := range names {
+		if fs.seen[name] {
+			continue
+		}
+		f := fs.named[name]
+		if f.preserved || f.opts.Has("allowdefault") && !f.v.IsZero() {
+			continue
+		}
+		if f.opts.Has("required") {
+			errs = append(errs, fieldError(name, errors.New("required")))
+			continue
+		}
+		for _, m := range f.opts.List("requiredfor") {
+			if strings.EqualFold(m, method) {
+				errs = append(errs, fieldError(name, fmt.Errorf("required for %s", method)))
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// unpackSourced populates the fields from the sources other than the form,
+// i.e., the fields tagged with the `rawquery` or `rawbody` option, or the `cookie`, `header`, `url`, `path` or `meta` tag.
+// The body is restored so that it can be parsed later.
+func (d *Decoder) unpackSourced(r *http.Request, fields []field) error {
+	for _, f := range fields {
+		switch f.source {
+		case "cookie":
+			if err := d.unpackCookie(r, f); err != nil {
+				return err
+			}
+		case "header":
+			if err := d.unpackHeader(r, f); err != nil {
+				return fieldError(f.name, err)
+			}
+		case "path":
+			value, ok := pathSegment(r.URL.Path, f.name)
+			if !ok {
+				continue // absent
+			}
+			if f.opts.Has("noext") {
+				value = strings.TrimSuffix(value, path.Ext(value))
+			}
+			if err := d.populate(f.v, value, &f); err != nil {
+				return fmt.Errorf("path %w", fieldError(f.name, err))
+			}
+		case "url", "meta":
+			component := urlComponents[f.name]
+			if f.source == "meta" {
+				component = requestMeta[f.name]
+			}
+			value := component(r)
+			if value == "" {
+				continue // absent, e.g., the port
+			}
+			if err := d.populate(f.v, value, &f); err != nil {
+				return fieldError(f.name, err)
+			}
+		case "rawquery":
+			if f.v.Kind() != reflect.String {
+				return fmt.Errorf("%s: unsupported raw query kind %s", f.name, f.v.Type())
+			}
+			f.v.SetString(r.URL.RawQuery)
+		case "rawbody":
+			var b []byte
+			if r.Body != nil {
+				var err error
+				if b, err = ioutil.ReadAll(r.Body); err != nil {
+					return err
+				}
+				r.Body.Close()
+				r.Body = ioutil.NopCloser(bytes.NewReader(b))
+			}
+			switch {
+			case f.v.Kind() == reflect.String:
+				f.v.SetString(string(b))
+			case f.v.Type() == bytesType:
+				f.v.SetBytes(b)
+			default:
+				return fmt.Errorf("%s: unsupported raw body kind %s", f.name, f.v.Type())
+			}
+		}
 	}
 	return nil
 }
+
+// unpackHeader populates the field tagged with `header` from the request header of its name if any.
+// A slice field takes the comma separated list elements of all the header values,
+// e.g., `Sec-WebSocket-Protocol: chat, superchat`, otherwise the first value is taken.
+func (d *Decoder) unpackHeader(r *http.Request, f field) error {
+	values := r.Header[f.name]
+	if len(values) == 0 {
+		return nil // absent
+	}
+	if !isSlice(f.v.Type()) {
+		return d.populate(f.v, values[0], &f)
+	}
+	var elems []string
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			elems = append(elems, strings.TrimSpace(elem))
+		}
+	}
+	return d.unpackField(f, elems)
+}
+
+// urlComponents is the request URL components of the `url` tag, e.g., `url:"host"`.
+var urlComponents = map[string]func(r *http.Request) string{
+	"host": requestHost,
+	"hostname": func(r *http.Request) string {
+		return (&url.URL{Host: requestHost(r)}).Hostname()
+	},
+	"port": func(r *http.Request) string {
+		return (&url.URL{Host: requestHost(r)}).Port()
+	},
+	"scheme": func(r *http.Request) string {
+		if r.URL.Scheme != "" {
+			return r.URL.Scheme
+		}
+		// The server requests have no scheme in the URL.
+		if r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	},
+	"path": func(r *http.Request) string {
+		return r.URL.Path
+	},
+}
+
+// pathSegment returns the segment of the URL path p by the selector of the `path` tag,
+// i.e., last or the index, e.g., `path:"last,noext"` for 2023 of /reports/2023.json.
+func pathSegment(p, selector string) (string, bool) {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	if selector == "last" {
+		last := segments[len(segments)-1]
+		return last, last != ""
+	}
+	i, _ := strconv.Atoi(selector) // validated
+	if i < 0 || i >= len(segments) || segments[i] == "" {
+		return "", false
+	}
+	return segments[i], true
+}
+
+// requestMeta is the request metadata of the `meta` tag, e.g., `meta:"remote_addr"` for the audit.
+var requestMeta = map[string]func(r *http.Request) string{
+	"method": func(r *http.Request) string {
+		if r.Method == "" {
+			return http.MethodGet // the client requests have it empty for GET
+		}
+		return r.Method
+	},
+	"remote_addr": func(r *http.Request) string {
+		return r.RemoteAddr
+	},
+	"proto": func(r *http.Request) string {
+		return r.Proto
+	},
+	"tls": func(r *http.Request) string {
+		return strconv.FormatBool(r.TLS != nil)
+	},
+}
+
+// requestHost returns the host of r, the server requests have it in r.Host rather than the URL.
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+// fieldsKey is the key of the fields cache.
+type fieldsKey struct {
+	t      reflect.Type
+	tagKey string
+}
+
+// cachedFieldSet is the fields of a struct type or the error building them.
+type cachedFieldSet struct {
+	fs  *fieldSet
+	err error
+}
+
+// fieldsCache caches the fields of the struct types by the tag key,
+// so the tags are parsed once per type rather than per request.
+var fieldsCache sync.Map // map[fieldsKey]cachedFieldSet
+
+// cachedFields returns the fields of the struct type t, which are not bound to any value yet.
+// The result must not be modified, see fieldSet.bind.
+func cachedFields(t reflect.Type, tagKey string) (*fieldSet, error) {
+	key := fieldsKey{t: t, tagKey: tagKey}
+	if c, ok := fieldsCache.Load(key); ok {
+		return c.(cachedFieldSet).fs, c.(cachedFieldSet).err
+	}
+	fs := newFieldSet()
+	err := buildFields(fs, t, nil, "", tagKey)
+	c, _ := fieldsCache.LoadOrStore(key, cachedFieldSet{fs: fs, err: err})
+	return c.(cachedFieldSet).fs, c.(cachedFieldSet).err
+}
+
+// bind returns a copy of the cached fields bound to the struct v by their indices.
+func (fs *fieldSet) bind(v reflect.Value) *fieldSet {
+	bound := &fieldSet{
+		named:    make(map[string]field, len(fs.named)),
+		prefixed: make([]field, len(fs.prefixed)),
+		sourced:  make([]field, len(fs.sourced)),
+		seen:     make(map[string]bool),
+	}
+	for name, f := range fs.named {
+		f.v = v.FieldByIndex(f.index)
+		bound.named[name] = f
+	}
+	for i, f := range fs.prefixed {
+		f.v = v.FieldByIndex(f.index)
+		bound.prefixed[i] = f
+	}
+	for i, f := range fs.sourced {
+		f.v = v.FieldByIndex(f.index)
+		bound.sourced[i] = f
+	}
+	return bound
+}
+
+// buildFields adds the fields of struct type t into fs keyed by effective name,
+// the fields are located by the index sequence, which is prefixed by index for the nested ones.
+// Nested struct fields are keyed by dotted path, e.g., `address.city`.
+func buildFields(fs *fieldSet, t reflect.Type, index []int, prefix, tagKey string) error {
+	for i := 0; i < t.NumField(); i++ {
+		fieldInfo := t.Field(i) // a reflect.StructField
+		tag := fieldInfo.Tag    // a reflect.StructTag
+		name, opts := parseTag(tag.Get(tagKey))
+		if name == "" {
+			// First letter to lower since most languages will style that way.
+			for i := range fieldInfo.Name {
+				name = strings.ToLower(fieldInfo.Name[:i+1]) + fieldInfo.Name[i+1:]
+				break
+			}
+		}
+		name = prefix + name
+		f := field{index: append(index[:len(index):len(index)], i), name: name, opts: opts}
+		if opts.Has("rawquery") || opts.Has("rawbody") {
+			f.source = "rawquery"
+			if opts.Has("rawbody") {
+				f.source = "rawbody"
+			}
+			fs.sourced = append(fs.sourced, f)
+			continue
+		}
+		if cookie, ok := tag.Lookup("cookie"); ok {
+			name, cookieOpts := parseTag(cookie)
+			f.name, f.source = name, "cookie"
+			for k, v := range cookieOpts {
+				f.opts[k] = v
+			}
+			fs.sourced = append(fs.sourced, f)
+			continue
+		}
+		if header, ok := tag.Lookup("header"); ok {
+			f.name, f.source = http.CanonicalHeaderKey(header), "header"
+			fs.sourced = append(fs.sourced, f)
+			continue
+		}
+		if component, ok := tag.Lookup("url"); ok {
+			if _, ok := urlComponents[component]; !ok {
+				return fmt.Errorf("%s: unknown url component %q", name, component)
+			}
+			f.name, f.source = component, "url"
+			fs.sourced = append(fs.sourced, f)
+			continue
+		}
+		if partHeader, ok := tag.Lookup("partheader"); ok {
+			i := strings.IndexByte(partHeader, ',')
+			if i <= 0 || i == len(partHeader)-1 {
+				return fmt.Errorf("%s: invalid part header %q, want the part name and header key", name, partHeader)
+			}
+			f.name, f.source = partHeader[:i], "partheader"
+			f.key = textproto.CanonicalMIMEHeaderKey(partHeader[i+1:])
+			fs.sourced = append(fs.sourced, f)
+			continue
+		}
+		if segment, ok := tag.Lookup("path"); ok {
+			selector, pathOpts := parseTag(segment)
+			if _, err := strconv.Atoi(selector); err != nil && selector != "last" {
+				return fmt.Errorf("%s: invalid path segment %q, want last or an index", name, selector)
+			}
+			f.name, f.source = selector, "path"
+			for k, v := range pathOpts {
+				f.opts[k] = v
+			}
+			fs.sourced = append(fs.sourced, f)
+			continue
+		}
+		if meta, ok := tag.Lookup("meta"); ok {
+			if _, ok := requestMeta[meta]; !ok {
+				return fmt.Errorf("%s: unknown request meta %q", name, meta)
+			}
+			f.name, f.source = meta, "meta"
+			fs.sourced = append(fs.sourced, f)
+			continue
+		}
+		if fieldInfo.Type.Kind() == reflect.Map && strings.HasSuffix(name, "*") {
+			if fieldInfo.Type.Key().Kind() != reflect.String {
+				return fmt.Errorf("%s: unsupported map key kind %s", name, fieldInfo.Type.Key())
+			}
+			f.name = strings.TrimSuffix(name, "*")
+			fs.prefixed = append(fs.prefixed, f)
+			continue
+		}
+		if fieldInfo.Type.Kind() == reflect.Map && !opts.Has("kvlist") && tag.Get(tagKey) != "-" {
+			// The keys of the bracket or dotted syntax, e.g., meta[foo]=bar or meta.foo=bar.
+			if fieldInfo.Type.Key().Kind() != reflect.String {
+				return fmt.Errorf("%s: unsupported map key kind %s", name, fieldInfo.Type.Key())
+			}
+			bracket, dotted := f, f
+			bracket.name, dotted.name = name+"[", name+"."
+			fs.prefixed = append(fs.prefixed, bracket, dotted)
+			continue
+		}
+		if tag.Get(tagKey) == "-" {
+			// Never bound from the form like encoding/json, e.g., IsAdmin against the mass assignment,
+			// while the sources of the other tags above still apply.
+			continue
+		}
+		if isNested(fieldInfo.Type) {
+			// The struct itself can be populated from a JSON value as well.
+			fs.named[name] = f
+			if err := buildFields(fs, fieldInfo.Type, f.index, name+".", tagKey); err != nil {
+				return err
+			}
+			continue
+		}
+		f.layout = tag.Get("time_format")
+		if layout, ok := opts["layout"]; ok {
+			f.layout = layout
+		}
+		if name := tag.Get("time_location"); name != "" {
+			var err error
+			if f.loc, err = time.LoadLocation(name); err != nil {
+				return fmt.Errorf("%s: invalid time location %q", f.name, name)
+			}
+		}
+		if max, ok := opts["max"]; ok {
+			var err error
+			if f.max, err = strconv.Atoi(max); err != nil {
+				return fmt.Errorf("%s: invalid max option %q", name, max)
+			}
+		}
+		fs.named[name] = f
+	}
+	return nil
+}
+
+var (
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isNested reports whether t is a struct whose fields are bound by dotted path,
+// rather than a struct type populated from a single value.
+func isNested(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == ratType {
+		return false
+	}
+	if _, ok := lookupParser(t); ok {
+		return false
+	}
+	return !reflect.PtrTo(t).Implements(binaryUnmarshalerType) && !isTextUnmarshaler(t)
+}
+
+// isTextUnmarshaler reports whether t is populated by its UnmarshalText from a single value,
+// e.g., net.IP which is a slice but not of the elements.
+func isTextUnmarshaler(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// field is a struct field to be populated.
+type field struct {
+	v      reflect.Value
+	index  []int // index sequence of the field in the struct, see reflect.Value.FieldByIndex
+	name   string
+	source string // where the value comes from other than the form, e.g., rawquery
+	key    string // the key in the source if other than the name, e.g., the part header key
+	opts   tagOptions
+	max    int            // max slice length, zero means not set
+	loc    *time.Location // time zone of the time.Time field, nil means UTC
+	layout string         // layout of the time.Time field, empty means the timeLayouts, unix means epoch seconds
+	// preserved is the field of a preset value not to be populated, see Decoder.PreserveNonZero.
+	preserved bool
+}
+
+func (d *Decoder) tag() string {
+	if d.Tag == "" {
+		return FieldTag
+	}
+	return d.Tag
+}
+
+func (d *Decoder) maxMemory() int64 {
+	if d.MaxMemory == 0 {
+		return MultipartMaxMemory
+	}
+	return d.MaxMemory
+}
+
+// collect appends the error to errs if d.CollectErrors, otherwise it returns the error as is.
+func (d *Decoder) collect(errs *MultiError, err error) error {
+	if !d.CollectErrors {
+		return err
+	}
+	*errs = append(*errs, err)
+	return nil
+}
+
+// collectAll appends the errors collected of a MultiError to errs if d.CollectErrors,
+// otherwise it returns the error as is.
+func (d *Decoder) collectAll(errs *MultiError, err error) error {
+	if m, ok := err.(MultiError); ok && d.CollectErrors {
+		*errs = append(*errs, m...)
+		return nil
+	}
+	return err
+}
+
+// collected returns the errors collected in the order of field name if any.
+func collected(errs MultiError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].(*FieldError).Field < errs[j].(*FieldError).Field
+	})
+	return errs
+}
+
+func (d *Decoder) unpack(fs *fieldSet, form map[string][]string) error {
+	fields := fs.named
+	if d.MaxFormFields > 0 {
+		n := 0
+		for _, values := range form {
+			n += len(values)
+		}
+		if n > d.MaxFormFields {
+			return fmt.Errorf("%d form fields exceed the max %d", n, d.MaxFormFields)
+		}
+	}
+	if d.CaseInsensitive {
+		form = foldValues(fs, form)
+	}
+	// Update struct field for each parameter in the request.
+	var errs MultiError
+	var keyed []string // the keys other than of the named fields, e.g., indexed or of a map
+	for name, values := range form {
+		f, ok := fields[name]
+		if !ok {
+			keyed = append(keyed, name)
+			continue
+		}
+		if f.preserved {
+			continue
+		}
+		fs.seen[name] = true
+		if err := d.unpackField(f, values); err != nil {
+			if err := d.collect(&errs, fieldError(name, err)); err != nil {
+				return err
+			}
+		}
+	}
+	// The keyed values are applied on top of the plain ones in order, e.g., items=a&items[2]=b is [a "" b].
+	sort.Strings(keyed)
+	for _, name := range keyed {
+		values := form[name]
+		if f, ok := matchPrefix(fs.prefixed, name); ok {
+			key := name[len(f.name):]
+			if strings.HasSuffix(f.name, "[") {
+				if strings.IndexByte(key, ']') != len(key)-1 || len(key) == 1 {
+					continue // ignore the malformed bracket keys, e.g., meta[foo
+				}
+				key = key[:len(key)-1]
+			}
+			fs.seen[prefixedName(f)] = true
+			if err := d.unpackMapEntry(f, key, values); err != nil {
+				if err := d.collect(&errs, fieldError(name, err)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.Contains(name, "].") {
+			if err := d.unpackGrouped(fs, name, values); err != nil {
+				if err := d.collect(&errs, fieldError(name, err)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := d.unpackIndexed(fs, name, values); err != nil {
+			if err := d.collect(&errs, fieldError(name, err)); err != nil {
+				return err
+			}
+		}
+	}
+	return collected(errs)
+}
+
+// prefixedName returns the name of the map field of a key prefix, e.g., `meta` of `meta[` or `meta.`,
+// or `filter_*` of `filter_`.
+func prefixedName(f field) string {
+	if strings.HasSuffix(f.name, "[") || strings.HasSuffix(f.name, ".") {
+		return f.name[:len(f.name)-1]
+	}
+	return f.name + "*"
+}
+
+// matchPrefix returns the map field of the longest prefix matching the key.
+func matchPrefix(fields []field, key string) (field, bool) {
+	var match field
+	for _, f := range fields {
+		if strings.HasPrefix(key, f.name) && len(key) > len(f.name) && len(f.name) > len(match.name) {
+			match = f
+		}
+	}
+	return match, match.v.IsValid()
+}
+
+// unpackMapEntry populates the map entry of the key.
+func (d *Decoder) unpackMapEntry(f field, key string, values []string) error {
+	if f.v.IsNil() {
+		f.v.Set(reflect.MakeMap(f.v.Type()))
+	}
+	k := reflect.ValueOf(key).Convert(f.v.Type().Key())
+	elem := reflect.New(f.v.Type().Elem()).Elem()
+	if isSlice(elem.Type()) {
+		// The repeated values are collected, so are those of the keys of both syntaxes, e.g., meta[foo] and meta.foo.
+		if existing := f.v.MapIndex(k); existing.IsValid() {
+			elem.Set(existing)
+		}
+		for _, value := range values {
+			e := reflect.New(elem.Type().Elem()).Elem()
+			if err := d.populate(e, value, &f); err != nil {
+				return err
+			}
+			elem.Set(reflect.Append(elem, e))
+		}
+		f.v.SetMapIndex(k, elem)
+		return nil
+	}
+	for _, value := range values {
+		if err := d.populate(elem, value, &f); err != nil {
+			return err
+		}
+	}
+	f.v.SetMapIndex(k, elem)
+	return nil
+}
+
+// populateKVList populates the map v from the comma separated key=value pairs of the kvlist option,
+// e.g., `json:"opts,kvlist"` for opts=color=red,size=large.
+func (d *Decoder) populateKVList(v reflect.Value, value string, f *field) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("unsupported map key kind %s", v.Type().Key())
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	if value == "" {
+		return nil // no pairs
+	}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid key=value pair %q", pair)
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := d.populateValue(elem, kv[1], f); err != nil {
+			return fmt.Errorf("%s: %v", kv[0], err)
+		}
+		v.SetMapIndex(reflect.ValueOf(kv[0]).Convert(v.Type().Key()), elem)
+	}
+	return nil
+}
+
+// defaultMaxSliceIndex is the max index of the indexed slice key if not configured.
+const defaultMaxSliceIndex = 1000
+
+// unpackIndexed populates the slice element of the explicitly indexed key, e.g., `items[1]=x`.
+// The slice grows to fit the index, a key not matching any slice field is ignored.
+// The empty index appends the values like the jQuery or PHP clients, e.g., `items[]=x&items[]=y`.
+func (d *Decoder) unpackIndexed(fs *fieldSet, key string, values []string) error {
+	i := strings.LastIndexByte(key, '[')
+	if i <= 0 || !strings.HasSuffix(key, "]") {
+		return nil // ignore unrecognized HTTP parameters
+	}
+	name := d.foldName(key[:i])
+	f, ok := fs.lookup(name)
+	if !ok || !isSlice(f.v.Type()) {
+		return nil
+	}
+	resetSlice(fs, name, f)
+	if i == len(key)-2 {
+		return d.appendIndexed(f, values)
+	}
+	index, ok, err := d.growIndexed(f, key[i+1:len(key)-1])
+	if !ok {
+		return err
+	}
+	for _, value := range values {
+		if err := d.populate(f.v.Index(index), value, &f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetSlice resets the slice field f of the name the first time it's present in the request,
+// so the values replace the stale elements, e.g., of a reused struct, like unpackField.
+func resetSlice(fs *fieldSet, name string, f field) {
+	if !fs.seen[name] {
+		f.v.Set(reflect.Zero(f.v.Type()))
+		fs.seen[name] = true
+	}
+}
+
+// appendIndexed appends the values of the empty index to the slice field f, bounded by the max slice length.
+func (d *Decoder) appendIndexed(f field, values []string) error {
+	max := d.maxSliceLen(f)
+	for _, value := range values {
+		if max > 0 && f.v.Len() >= max {
+			if d.TruncateSlices {
+				return nil
+			}
+			return fmt.Errorf("%d elements exceed the max %d", f.v.Len()+1, max)
+		}
+		elem := reflect.New(f.v.Type().Elem()).Elem()
+		if err := d.populate(elem, value, &f); err != nil {
+			return err
+		}
+		f.v.Set(reflect.Append(f.v, elem))
+	}
+	return nil
+}
+
+// unpackGrouped populates the field of the struct element of the explicitly indexed key,
+// e.g., `items[1].name=x` of the field Items []Item. The keys of an element are grouped by the index
+// regardless of their order, and the slice grows to fit the index like unpackIndexed.
+func (d *Decoder) unpackGrouped(fs *fieldSet, key string, values []string) error {
+	i := strings.IndexByte(key, '[')
+	j := strings.Index(key, "].")
+	if i <= 0 || j < i {
+		return nil // ignore unrecognized HTTP parameters
+	}
+	name := d.foldName(key[:i])
+	f, ok := fs.lookup(name)
+	if !ok || !isSlice(f.v.Type()) || !isNested(f.v.Type().Elem()) {
+		return nil
+	}
+	resetSlice(fs, name, f)
+	index, ok, err := d.growIndexed(f, key[i+1:j])
+	if !ok {
+		return err
+	}
+	elem, err := d.fieldsOf(f.v.Index(index))
+	if err != nil {
+		return err
+	}
+	// The element is bound again for each key since the slice may grow,
+	// while the keys seen of it are kept, e.g., for its slice fields reset once.
+	group := name + "[" + strconv.Itoa(index) + "]"
+	if seen, ok := fs.groups[group]; ok {
+		elem.seen = seen
+	} else {
+		if fs.groups == nil {
+			fs.groups = make(map[string]map[string]bool)
+		}
+		fs.groups[group] = elem.seen
+	}
+	// The element is bound by the rest of the key alone, which fails fast,
+	// the key as a whole is completed by the caller.
+	sub := *d
+	sub.MaxFormFields, sub.CollectErrors = 0, false
+	err = sub.unpack(elem, map[string][]string{key[j+2:]: values})
+	if fe, ok := err.(*FieldError); ok {
+		fe.Field = ""
+	}
+	return err
+}
+
+// maxSliceLen returns the max length of the slice field f, zero means unlimited.
+func (d *Decoder) maxSliceLen(f field) int {
+	if f.max > 0 {
+		return f.max
+	}
+	return d.MaxSliceLen
+}
+
+// growIndexed grows the slice field f to fit the index of the indexed key, it returns the index.
+// The index is bounded by the max slice length as well, the key beyond which is dropped
+// with the TruncateSlices option, i.e., ok is false.
+func (d *Decoder) growIndexed(f field, s string) (index int, ok bool, err error) {
+	index, err = strconv.Atoi(s)
+	if err != nil || index < 0 {
+		return 0, false, fmt.Errorf("invalid index %q", s)
+	}
+	max := d.MaxSliceIndex
+	if max == 0 {
+		max = defaultMaxSliceIndex
+	}
+	if index > max {
+		return 0, false, fmt.Errorf("index %d exceeds the max %d", index, max)
+	}
+	if max := d.maxSliceLen(f); max > 0 && index >= max {
+		if d.TruncateSlices {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("index %d exceeds the max %d elements", index, max)
+	}
+	v := f.v
+	if index >= v.Len() {
+		s := reflect.MakeSlice(v.Type(), index+1, index+1)
+		reflect.Copy(s, v)
+		v.Set(s)
+	}
+	return index, true, nil
+}
+
+// unpackField populates the field from the values of a repeated key,
+// the last value wins for a scalar field, while the values replace the elements of a slice field,
+// unless the `replace` option is set which keeps the last one only like a scalar field.
+func (d *Decoder) unpackField(f field, values []string) error {
+	if !isSlice(f.v.Type()) {
+		for _, value := range values {
+			if err := d.populate(f.v, value, &f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if f.opts.Has("replace") && len(values) > 0 {
+		// The last occurrence replaces the prior ones like a scalar.
+		values = values[len(values)-1:]
+	}
+	if f.opts.Has("lines") {
+		values = splitLines(values)
+	}
+	if isCSV(f.opts) && !isRow(f.v.Type().Elem()) {
+		values = splitCSV(values, delimiter(f.opts))
+	}
+	if d.EmptyArrayElement == EmptyElementDrop || f.opts.Has("skipempty") {
+		nonempty := make([]string, 0, len(values))
+		for _, value := range values {
+			if value != "" {
+				nonempty = append(nonempty, value)
+			}
+		}
+		values = nonempty
+	} else if d.EmptyArrayElement == EmptyElementError {
+		for i, value := range values {
+			if value == "" {
+				return fmt.Errorf("empty element at %d", i)
+			}
+		}
+	}
+
+	max := d.maxSliceLen(f)
+	if max > 0 && len(values) > max {
+		if !d.TruncateSlices {
+			return fmt.Errorf("%d elements exceed the max %d", len(values), max)
+		}
+		values = values[:max]
+	}
+
+	// The values replace the existing elements, e.g., of a reused struct or the defaults.
+	s := reflect.MakeSlice(f.v.Type(), 0, len(values))
+	if !d.AtomicSlices {
+		f.v.Set(s)
+	} // Otherwise build into the temporary slice, assign only if all elements are parsed.
+	for _, value := range values {
+		elem := reflect.New(s.Type().Elem()).Elem()
+		if err := d.populate(elem, value, &f); err != nil {
+			if !d.AtomicSlices {
+				f.v.Set(s)
+			}
+			return err
+		}
+		s = reflect.Append(s, elem)
+	}
+	f.v.Set(s)
+	return nil
+}
+
+// splitCSV splits each of the values on the separator into elements,
+// e.g., ids=1,2,3 of the csv option, or the explode=false option borrowed from OpenAPI.
+func splitCSV(values []string, sep string) []string {
+	var elems []string
+	for _, value := range values {
+		for _, elem := range strings.Split(value, sep) {
+			elems = append(elems, strings.TrimSpace(elem))
+		}
+	}
+	return elems
+}
+
+// isCSV reports whether the values are comma separated, i.e., of the csv or explode=false option.
+func isCSV(opts tagOptions) bool {
+	return opts.Has("csv") || opts["explode"] == "false"
+}
+
+// delimiter returns the separator of the comma separated values, which is comma unless the `delim` option,
+// e.g., `json:"ids,explode=false,delim=;"` for ids=1;2;3.
+func delimiter(opts tagOptions) string {
+	if delim := opts["delim"]; delim != "" {
+		return delim
+	}
+	return ","
+}
+
+// isRow reports whether t is a slice populated from a single comma separated value of the csv option,
+// e.g., the []int elements of a [][]int field for rows=1,2,3&rows=4,5,6.
+func isRow(t reflect.Type) bool {
+	return isSlice(t)
+}
+
+// isSlice reports whether t is a slice populated element by element from the repeated values,
+// rather than from a single value, e.g., []byte from base64 or net.IP from text.
+func isSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 && !isTextUnmarshaler(t)
+}
+
+// populateRow populates the slice v from the comma separated value.
+func (d *Decoder) populateRow(v reflect.Value, value string, f *field) error {
+	elems := splitCSV([]string{value}, delimiter(f.opts))
+	row := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+	for i, elem := range elems {
+		if err := d.populateValue(row.Index(i), elem, f); err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+	}
+	v.Set(row)
+	return nil
+}
+
+// unpackPartHeaders populates the fields tagged with `partheader` from the header of the file parts,
+// e.g., `partheader:"file,X-Checksum"` binds the X-Checksum header of the file part, one value per part.
+func (d *Decoder) unpackPartHeaders(fields []field, m map[string][]*multipart.FileHeader) error {
+	for _, f := range fields {
+		if f.source != "partheader" {
+			continue
+		}
+		var values []string
+		for _, part := range m[f.name] {
+			if value := part.Header.Get(f.key); value != "" {
+				values = append(values, value)
+			}
+		}
+		if len(values) == 0 {
+			continue // absent
+		}
+		if err := d.unpackField(f, values); err != nil {
+			return fieldError(f.name+" "+f.key, err)
+		}
+	}
+	return nil
+}
+
+// splitLines splits each of the values on newlines into elements, e.g., of a textarea,
+// either "\n" or "\r\n" is accepted. The empty lines are kept as the empty elements.
+func splitLines(values []string) []string {
+	var lines []string
+	for _, value := range values {
+		for _, line := range strings.Split(value, "\n") {
+			lines = append(lines, strings.TrimSuffix(line, "\r"))
+		}
+	}
+	return lines
+}
+
+func (d *Decoder) unpackMultipart(fs *fieldSet, m map[string][]*multipart.FileHeader) error {
+	maxFile, maxTotal := d.MaxFileSize, d.MaxTotalUploadSize
+	if maxFile == 0 {
+		maxFile = MaxFileSize
+	}
+	if maxTotal == 0 {
+		maxTotal = MaxTotalUploadSize
+	}
+	if err := checkUploadSize(m, maxFile, maxTotal); err != nil {
+		return err
+	}
+	var errs MultiError
+	var opened []io.Closer // the files opened, which are abandoned on failure
+	for name, parts := range m {
+		name = d.foldName(name)
+		ff, ok := fs.lookup(name)
+		if !ok {
+			continue // ignore unrecognized HTTP parameters
+		}
+		if ff.v.Kind() == reflect.Slice {
+			resetSlice(fs, name, ff)
+		}
+		fs.seen[name] = true
+		if err := d.unpackParts(ff, parts, &opened); err != nil {
+			if err := d.collect(&errs, fieldError(name, err)); err != nil {
+				closeAll(opened)
+				return err
+			}
+		}
+	}
+	if err := collected(errs); err != nil {
+		closeAll(opened)
+		return err
+	}
+	return nil
+}
+
+// unpackParts populates the field from its file parts, the files opened are appended to opened.
+// All the parts are checked before populating, and the last part wins for a non-slice field,
+// so no file is opened only to be replaced or abandoned.
+func (d *Decoder) unpackParts(ff field, parts []*multipart.FileHeader, opened *[]io.Closer) error {
+	f := ff.v
+	if d.StrictSingleFile && f.Kind() != reflect.Slice && len(parts) > 1 {
+		return fmt.Errorf("%d files for a single file field", len(parts))
+	}
+	if accept, ok := ff.opts["accept"]; ok {
+		for _, part := range parts {
+			if err := checkContentType(part, strings.Split(accept, ";"), ff.opts.Has("sniff")); err != nil {
+				return err
+			}
+		}
+	}
+	if f.Kind() != reflect.Slice {
+		return populatePart(f, parts[len(parts)-1], opened)
+	}
+	for _, part := range parts {
+		elem := reflect.New(f.Type().Elem()).Elem()
+		if err := populatePart(elem, part, opened); err != nil {
+			return err
+		}
+		f.Set(reflect.Append(f, elem))
+	}
+	return nil
+}
+
+// closeAll closes the files, e.g., opened for a failed bind.
+func closeAll(files []io.Closer) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// checkUploadSize checks the size of each file against maxFile and the total of them against maxTotal,
+// zero means unlimited. The files are checked in the order of name, so the error is deterministic.
+func checkUploadSize(m map[string][]*multipart.FileHeader, maxFile, maxTotal int64) error {
+	if maxFile <= 0 && maxTotal <= 0 {
+		return nil
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var total int64
+	for _, name := range names {
+		for _, part := range m[name] {
+			if maxFile > 0 && part.Size > maxFile {
+				return fieldError(name, fmt.Errorf("file %q of %d bytes exceeds the max file size %d", part.Filename, part.Size, maxFile))
+			}
+			total += part.Size
+			if maxTotal > 0 && total > maxTotal {
+				return fieldError(name, fmt.Errorf("file %q of %d bytes exceeds the max total upload size %d, %d bytes in total", part.Filename, part.Size, maxTotal, total))
+			}
+		}
+	}
+	return nil
+}
+
+// checkContentType checks the media type of the file part against the accepted ones of the `accept` option,
+// e.g., `json:"avatar,accept=image/png;image/jpeg"`, a subtype wildcard like image/* matches any image.
+// With the `sniff` option, the content type detected from the first 512 bytes must be accepted as well,
+// since the declared one is up to the client.
+func checkContentType(part *multipart.FileHeader, accepts []string, sniff bool) error {
+	declared, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if !acceptMediaType(declared, accepts) {
+		return fmt.Errorf("file %q of content type %q is not accepted", part.Filename, declared)
+	}
+	if !sniff {
+		return nil
+	}
+	file, err := part.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(file, 512))
+	if err != nil {
+		return err
+	}
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(b))
+	if !acceptMediaType(detected, accepts) {
+		return fmt.Errorf("file %q of content type %q is detected as %q which is not accepted", part.Filename, declared, detected)
+	}
+	return nil
+}
+
+// acceptMediaType reports whether the media type matches any of the accepted ones, case-insensitively.
+func acceptMediaType(mediaType string, accepts []string) bool {
+	if mediaType == "" {
+		return false
+	}
+	for _, accept := range accepts {
+		accept = strings.ToLower(strings.TrimSpace(accept))
+		if accept == mediaType || strings.HasSuffix(accept, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accept, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// populatePart sets v from the file part, either the *multipart.FileHeader,
+// or the file opened for the interface it implements, e.g., multipart.File or io.ReadCloser,
+// which the caller must Close. The file opened is appended to opened.
+func populatePart(v reflect.Value, part *multipart.FileHeader, opened *[]io.Closer) error {
+	if v.Kind() == reflect.Interface && v.NumMethod() > 0 && fileType.Implements(v.Type()) {
+		f, err := part.Open()
+		if err != nil {
+			return err
+		}
+		*opened = append(*opened, f)
+		v.Set(reflect.ValueOf(f))
+		return nil
+	}
+	if fileHeaderPtrType != v.Type() {
+		return fmt.Errorf("unsupported multipart kind %s", v.Kind())
+	}
+	v.Set(reflect.ValueOf(part))
+	return nil
+}
+
+// populate sets v, the field f or its element, from the value with respect to the field tag options.
+// The value is redacted from the error of the field tagged with the secret option, e.g., `json:"token,secret"`.
+func (d *Decoder) populate(v reflect.Value, value string, f *field) error {
+	if d.FieldTiming != nil {
+		defer func(start time.Time) {
+			d.FieldTiming(f.name, time.Since(start))
+		}(time.Now())
+	}
+	err := d.populateValue(v, value, f)
+	if err == nil {
+		return nil
+	}
+	if value != "" && f.opts.Has("secret") {
+		// The message is never derived from the original error, which may carry the value in any form,
+		// e.g., quoted, escaped or in part.
+		err = &redactedError{msg: fmt.Sprintf("invalid value %s (%T)", redacted, err)}
+		value = redacted
+	}
+	// The field name is completed by the caller which knows the key, e.g., of an indexed or map entry.
+	return &FieldError{Value: value, Err: err}
+}
+
+// redacted replaces the secret values in errors.
+const redacted = "[REDACTED]"
+
+// redactedError is an error whose message has the secret values redacted.
+// It doesn't wrap the original error on purpose, which may carry the value.
+type redactedError struct {
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (d *Decoder) populateValue(v reflect.Value, value string, f *field) error {
+	opts := f.opts
+	if name, ok := opts["factory"]; ok {
+		return populateFactory(v, value, name)
+	}
+	if parser, ok := lookupParser(v.Type()); ok {
+		return populateParser(v, value, parser)
+	}
+	if v.Kind() == reflect.Ptr && v.Type() != fileHeaderPtrType {
+		// A pointer is allocated only if present, so nil means absent, e.g., for the patch.
+		elem := reflect.New(v.Type().Elem())
+		if err := d.populateValue(elem.Elem(), value, f); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+	if v.Type() == timeType {
+		loc := f.loc
+		if loc == nil {
+			loc = time.UTC
+		}
+		t, err := parseTime(value, f.layout, loc)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if v.Type() == ratType {
+		// Exact ratios, e.g., 3/4 or 0.75, without the precision loss of float64.
+		r, ok := new(big.Rat).SetString(value)
+		if !ok {
+			return fmt.Errorf("invalid rational number %q", value)
+		}
+		v.Set(reflect.ValueOf(r).Elem())
+		return nil
+	}
+	if enc, ok := opts["encoding"]; ok && v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.BinaryUnmarshaler); ok {
+			b, err := decodeBytes(value, enc)
+			if err != nil {
+				return err
+			}
+			return u.UnmarshalBinary(b)
+		}
+	}
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(value))
+		}
+	}
+	if v.Type() == numberType {
+		// The exact representation, e.g., of the large integers or decimals, unlike float64.
+		var n json.Number
+		if err := json.Unmarshal([]byte(value), &n); err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.Set(reflect.ValueOf(n))
+		return nil
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		// The binary blob, e.g., data=SGVsbG8=, rather than the elements.
+		enc, ok := opts["encoding"]
+		if !ok {
+			enc = "base64"
+		}
+		b, err := decodeBytes(value, enc)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(b)
+		return nil
+	}
+	if opts.Has("kvlist") && v.Kind() == reflect.Map {
+		return d.populateKVList(v, value, f)
+	}
+	if isCSV(opts) && isRow(v.Type()) {
+		return d.populateRow(v, value, f)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if opts.Has("unhtml") {
+			value = html.UnescapeString(value)
+		}
+		if d.NormalizeNFC || opts.Has("nfc") {
+			value = norm.NFC.String(value)
+		}
+		value, err := truncate(value, opts)
+		if err != nil {
+			return err
+		}
+		v.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		base, err := intBase(opts)
+		if err != nil {
+			return err
+		}
+		i, err := strconv.ParseInt(value, base, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		base, err := intBase(opts)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(value, "-") {
+			return fmt.Errorf("negative value %q for unsigned %s", value, v.Kind())
+		}
+		u, err := strconv.ParseUint(value, base, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Bool:
+		b, err := d.parseBool(value, opts)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Struct:
+		// A JSON value, e.g., a multipart part carries the metadata of a sibling file part.
+		if err := json.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported kind %s", v.Type())
+	}
+	return nil
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]func(string) (interface{}, error))
+)
+
+// RegisterFactory registers the factory by name, which produces the field value from the raw value,
+// for the fields tagged with the factory option, e.g., `json:"slug,factory=slugify"`.
+// It's safe for concurrent use, however, it's usually called in init.
+func RegisterFactory(name string, factory func(value string) (interface{}, error)) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = make(map[reflect.Type]func(string) (interface{}, error))
+)
+
+// RegisterParser registers the parser of the type typ, which produces the field value from the raw value,
+// for all the fields of the type without tagging, e.g., a Filter type of a custom query syntax.
+// It's safe for concurrent use, however, it's usually called in init.
+func RegisterParser(typ reflect.Type, parser func(value string) (interface{}, error)) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	parsers[typ] = parser
+	// A struct of the type is no longer nested, so are the cached fields stale.
+	fieldsCache.Range(func(key, _ interface{}) bool {
+		fieldsCache.Delete(key)
+		return true
+	})
+}
+
+func lookupParser(typ reflect.Type) (func(string) (interface{}, error), bool) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	parser, ok := parsers[typ]
+	return parser, ok
+}
+
+func populateParser(v reflect.Value, value string, parser func(string) (interface{}, error)) error {
+	x, err := parser(value)
+	if err != nil {
+		return err
+	}
+	xv := reflect.ValueOf(x)
+	if !xv.IsValid() || !xv.Type().AssignableTo(v.Type()) {
+		return fmt.Errorf("parser of %s produces %T, not assignable", v.Type(), x)
+	}
+	v.Set(xv)
+	return nil
+}
+
+func populateFactory(v reflect.Value, value, name string) error {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown factory %s", name)
+	}
+	x, err := factory(value)
+	if err != nil {
+		return err
+	}
+	xv := reflect.ValueOf(x)
+	if !xv.IsValid() || !xv.Type().AssignableTo(v.Type()) {
+		return fmt.Errorf("factory %s produces %T, not assignable to %s", name, x, v.Type())
+	}
+	v.Set(xv)
+	return nil
+}
+
+// timeLayouts is the layouts tried in order to parse a time.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// unixLayout is the layout sentinel of the Unix epoch seconds, e.g., `json:"from,layout=unix"`.
+const unixLayout = "unix"
+
+// parseTime parses the value in the layout if any, otherwise in one of the timeLayouts,
+// a value without time zone is interpreted in the loc.
+func parseTime(value, layout string, loc *time.Location) (time.Time, error) {
+	switch layout {
+	case "":
+	case unixLayout:
+		sec, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix time %q", value)
+		}
+		return time.Unix(sec, 0).In(loc), nil
+	default:
+		return time.ParseInLocation(layout, value, loc)
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// truncate truncates the value to the characters of the maxlen option if any, e.g., `json:"bio,maxlen=500"`,
+// or fails with the strictlen option.
+func truncate(value string, opts tagOptions) (string, error) {
+	maxlen, ok := opts["maxlen"]
+	if !ok {
+		return value, nil
+	}
+	n, err := strconv.Atoi(maxlen)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("invalid maxlen option %q", maxlen)
+	}
+	if utf8.RuneCountInString(value) <= n {
+		return value, nil
+	}
+	if opts.Has("strictlen") {
+		return "", fmt.Errorf("length exceeds the maxlen %d", n)
+	}
+	for i := range value {
+		if n == 0 {
+			return value[:i], nil
+		}
+		n--
+	}
+	return value, nil
+}
+
+// intBase returns the base of the tag option, e.g., `json:"reg,base=16"`, default to 10.
+func intBase(opts tagOptions) (int, error) {
+	base, ok := opts["base"]
+	if !ok {
+		return 10, nil
+	}
+	i, err := strconv.Atoi(base)
+	if err != nil || i < 2 || i > 36 {
+		return 0, fmt.Errorf("invalid base %q", base)
+	}
+	return i, nil
+}
+
+// parseBool parses the bool value, the integers are parsed by the IntBool policy.
+// Besides those of strconv.ParseBool, on/yes and off/no are accepted case-insensitively, so is the empty value as true.
+func (d *Decoder) parseBool(value string, opts tagOptions) (bool, error) {
+	if containsFold(d.TrueValues, value) {
+		return true, nil
+	}
+	if containsFold(d.FalseValues, value) {
+		return false, nil
+	}
+	switch strings.ToLower(value) {
+	case "", "on", "yes":
+		// A present key without value is a flag or checked by the checkbox convention, e.g., ?verbose or ?remember,
+		// unless the FalseValues says otherwise.
+		return true, nil
+	case "off", "no":
+		return false, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return strconv.ParseBool(value)
+	}
+	policy := d.IntBool
+	switch opts["intbool"] {
+	case "strict":
+		policy = IntBoolStrict
+	case "lenient":
+		policy = IntBoolLenient
+	}
+	if policy == IntBoolLenient {
+		return i != 0, nil
+	}
+	if i != 0 && i != 1 {
+		return false, fmt.Errorf("invalid bool %s, want 0 or 1", value)
+	}
+	return i == 1, nil
+}
+
+func containsFold(a []string, s string) bool {
+	for _, v := range a {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBytes decodes the value with the encoding of the tag option, e.g., `json:"data,encoding=hex"`,
+// the raw encoding takes the value as is.
+func decodeBytes(value, enc string) ([]byte, error) {
+	switch enc {
+	case "raw":
+		return []byte(value), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(value)
+	case "hex":
+		return hex.DecodeString(value)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", enc)
+	}
+}
@@ -1,11 +1,28 @@
 package form_test
 
 import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/longkai/encoding/form"
 )
@@ -223,3 +240,3676 @@ func comparePart(part1, part2 *multipart.FileHeader) bool {
 	}
 	return true
 }
+
+func TestDecoderAtomicSlices(t *testing.T) {
+	type Params struct {
+		Array []int `json:"array"`
+	}
+	testCases := []struct {
+		desc    string
+		query   string
+		atomic  bool
+		want    []int
+		wantErr bool
+	}{
+		{
+			desc:  "all elements ok",
+			query: "array=1&array=2&array=3",
+			want:  []int{1, 2, 3},
+		},
+		{
+			desc:    "malformed element under atomic",
+			query:   "array=1&array=bad&array=3",
+			atomic:  true,
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			desc:   "all elements ok under atomic",
+			query:  "array=1&array=2&array=3",
+			atomic: true,
+			want:   []int{1, 2, 3},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			d := form.Decoder{AtomicSlices: c.atomic}
+			if err := d.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query, err, c.wantErr)
+			}
+			if !reflect.DeepEqual(params.Array, c.want) {
+				t.Errorf("Unpack(%s) = %v, want %v", c.query, params.Array, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderMaxSliceLen(t *testing.T) {
+	type Params struct {
+		Array []int `json:"array"`
+		IDs   []int `json:"ids,max=3"`
+	}
+	testCases := []struct {
+		desc    string
+		query   string
+		decoder form.Decoder
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc:    "global cap",
+			query:   "array=1&array=2&array=3",
+			decoder: form.Decoder{MaxSliceLen: 3},
+			want:    Params{Array: []int{1, 2, 3}},
+		},
+		{
+			desc:    "global cap exceeded",
+			query:   "array=1&array=2&array=3",
+			decoder: form.Decoder{MaxSliceLen: 2},
+			wantErr: true,
+		},
+		{
+			desc:    "global cap truncated",
+			query:   "array=1&array=2&array=3",
+			decoder: form.Decoder{MaxSliceLen: 2, TruncateSlices: true},
+			want:    Params{Array: []int{1, 2}},
+		},
+		{
+			desc:    "per-field cap overrides global",
+			query:   "ids=1&ids=2&ids=3",
+			decoder: form.Decoder{MaxSliceLen: 2},
+			want:    Params{IDs: []int{1, 2, 3}},
+		},
+		{
+			desc:    "per-field cap exceeded",
+			query:   "ids=1&ids=2&ids=3&ids=4",
+			wantErr: true,
+		},
+		{
+			desc:    "per-field cap truncated",
+			query:   "ids=1&ids=2&ids=3&ids=4",
+			decoder: form.Decoder{TruncateSlices: true},
+			want:    Params{IDs: []int{1, 2, 3}},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := c.decoder.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query, err, c.wantErr)
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) = %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderValidators(t *testing.T) {
+	type Params struct {
+		Q    string `json:"q"`
+		Page int    `json:"page"`
+	}
+	d := form.Decoder{
+		Validators: map[string]func(string, reflect.Value) error{
+			"q": func(name string, v reflect.Value) error {
+				if v.String() == "" {
+					return errors.New("must not be empty")
+				}
+				return nil
+			},
+			"page": func(name string, v reflect.Value) error {
+				if v.Int() < 1 {
+					return fmt.Errorf("%d out of range", v.Int())
+				}
+				return nil
+			},
+		},
+	}
+	testCases := []struct {
+		desc    string
+		query   string
+		wantErr string
+	}{
+		{
+			desc:  "valid",
+			query: "q=golang&page=1",
+		},
+		{
+			desc:    "one invalid",
+			query:   "q=golang&page=0",
+			wantErr: "page: 0 out of range",
+		},
+		{
+			desc:    "all invalid",
+			query:   "page=-1",
+			wantErr: "page: -1 out of range; q: must not be empty",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			err = d.Unpack(req, &params, form.Query)
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unpack(%s): %+v", c.query, err)
+				}
+				return
+			}
+			var multi form.MultiError
+			if !errors.As(err, &multi) {
+				t.Errorf("Unpack(%s) err = %v, want MultiError", c.query, err)
+				return
+			}
+			if err.Error() != c.wantErr {
+				t.Errorf("Unpack(%s) err = %q, want %q", c.query, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecoderLiteralPlus(t *testing.T) {
+	type Params struct {
+		Q string `json:"q"`
+		S string `json:"s"`
+	}
+	testCases := []struct {
+		desc        string
+		literalPlus bool
+		option      form.Option
+		want        Params
+	}{
+		{
+			desc:   "plus as space",
+			option: form.Body,
+			want:   Params{Q: "a b"},
+		},
+		{
+			desc:        "plus as literal",
+			literalPlus: true,
+			option:      form.Body,
+			want:        Params{Q: "a+b"},
+		},
+		{
+			desc:        "plus as literal mixed with query",
+			literalPlus: true,
+			option:      form.Mixed,
+			want:        Params{Q: "a+b", S: "c d"},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com?s=c+d", strings.NewReader("q=a+b"))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			var params Params
+			d := form.Decoder{LiteralPlus: c.literalPlus}
+			if err := d.Unpack(req, &params, c.option); err != nil {
+				t.Errorf("Unpack: %+v", err)
+			}
+			if params != c.want {
+				t.Errorf("Unpack = %+v, want %+v", params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackRaw(t *testing.T) {
+	var params struct {
+		Q        string `json:"q"`
+		RawQuery string `json:",rawquery"`
+		RawBody  []byte `json:",rawbody"`
+		Body     string `json:",rawbody"`
+	}
+	const rawQuery = "sig=ab%2Fcd&q=hello+world&q=%E4%BD%A0"
+	const body = "q=golang"
+	req, err := http.NewRequest(http.MethodPost, "http://google.com?"+rawQuery, strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := form.Unpack(req, &params); err != nil {
+		t.Errorf("Unpack: %+v", err)
+	}
+	if params.RawQuery != rawQuery {
+		t.Errorf("raw query got %q, want %q", params.RawQuery, rawQuery)
+	}
+	if string(params.RawBody) != body || params.Body != body {
+		t.Errorf("raw body got %q and %q, want %q", params.RawBody, params.Body, body)
+	}
+	if params.Q != "golang" {
+		t.Errorf("field q got %q, want %q", params.Q, "golang")
+	}
+}
+
+func TestUnpackBoolFlag(t *testing.T) {
+	type Params struct {
+		Verbose bool `json:"verbose"`
+		Debug   bool `json:"debug"`
+	}
+	testCases := []struct {
+		desc   string
+		query  string
+		params Params
+		want   Params
+	}{
+		{
+			desc:  "bare flag",
+			query: "verbose",
+			want:  Params{Verbose: true},
+		},
+		{
+			desc:  "bare flag with equal sign",
+			query: "verbose=",
+			want:  Params{Verbose: true},
+		},
+		{
+			desc:  "explicit true",
+			query: "verbose=true",
+			want:  Params{Verbose: true},
+		},
+		{
+			desc:   "explicit false",
+			query:  "verbose&debug=false",
+			params: Params{Debug: true},
+			want:   Params{Verbose: true, Debug: false},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			if err := form.UnpackWithOption(req, &c.params, form.Query); err != nil {
+				t.Errorf("Unpack(%s): %+v", c.query, err)
+			}
+			if c.params != c.want {
+				t.Errorf("Unpack(%s) = %+v, want %+v", c.query, c.params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackNamedSliceTypes(t *testing.T) {
+	type ID int
+	type IDs []int
+	type NamedIDs []ID
+	type Tag string
+	type Params struct {
+		IDs      IDs      `json:"ids"`
+		NamedIDs NamedIDs `json:"namedIds"`
+		Tags     []Tag    `json:"tags"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?ids=1&ids=2&namedIds=3&namedIds=4&tags=a&tags=b", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	for _, d := range []form.Decoder{{}, {AtomicSlices: true}} {
+		var params Params
+		if err := d.Unpack(req, &params, form.Query); err != nil {
+			t.Errorf("Unpack: %+v", err)
+		}
+		want := Params{IDs: IDs{1, 2}, NamedIDs: NamedIDs{3, 4}, Tags: []Tag{"a", "b"}}
+		if !reflect.DeepEqual(params, want) {
+			t.Errorf("Unpack = %+v, want %+v", params, want)
+		}
+	}
+}
+
+func TestUnpackMultipartFileMetadata(t *testing.T) {
+	var params struct {
+		Chunk       *multipart.FileHeader `json:"chunk"`
+		ChunkOffset int                   `json:"chunk_offset"`
+		ChunkTotal  int                   `json:"chunk_total"`
+	}
+	// The metadata parts come before and after the file part.
+	body := `------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="chunk_offset"
+
+1024
+------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="chunk"; filename="blob"
+Content-Type: application/octet-stream
+
+hello, world
+
+------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="chunk_total"
+
+4096
+------WebKitFormBoundarykhWusB7Rx4ybHQtA--`
+	r, err := http.NewRequest(http.MethodPost, "https://google.com/", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request fail: %+v", err)
+		return
+	}
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundarykhWusB7Rx4ybHQtA")
+	if err := form.UnpackWithOption(r, &params, form.Multipart); err != nil {
+		t.Errorf("UnpackWithOption: %+v", err)
+		return
+	}
+	if params.ChunkOffset != 1024 || params.ChunkTotal != 4096 {
+		t.Errorf("chunk metadata got offset %d total %d, want 1024 and 4096", params.ChunkOffset, params.ChunkTotal)
+	}
+	want := &multipart.FileHeader{Filename: "blob", Size: int64(len("hello, world\n"))}
+	if params.Chunk == nil || !comparePart(params.Chunk, want) {
+		t.Errorf("chunk got %+v, want %+v", params.Chunk, want)
+	}
+}
+
+func TestDecoderZeroBeforeBind(t *testing.T) {
+	type Params struct {
+		Q     string `json:"q"`
+		Page  int    `json:"page"`
+		Array []int  `json:"array"`
+	}
+	d := form.Decoder{ZeroBeforeBind: true}
+	var params Params // reused across requests
+	for _, c := range []struct {
+		query string
+		want  Params
+	}{
+		{query: "q=golang&page=2&array=1&array=2", want: Params{Q: "golang", Page: 2, Array: []int{1, 2}}},
+		{query: "q=rust", want: Params{Q: "rust"}},
+		{query: "page=3", want: Params{Page: 3}},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		if err := d.Unpack(req, &params, form.Query); err != nil {
+			t.Errorf("Unpack(%s): %+v", c.query, err)
+		}
+		if !reflect.DeepEqual(params, c.want) {
+			t.Errorf("Unpack(%s) = %+v, want %+v", c.query, params, c.want)
+		}
+	}
+}
+
+func TestUnpackSkipEmpty(t *testing.T) {
+	var params struct {
+		Tags      []string `json:"tags"`
+		SkipEmpty []string `json:"skip,skipempty"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?tags=a&tags=&tags=c&skip=a&skip=&skip=c", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack: %+v", err)
+	}
+	if want := []string{"a", "", "c"}; !reflect.DeepEqual(params.Tags, want) {
+		t.Errorf("preserved got %q, want %q", params.Tags, want)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(params.SkipEmpty, want) {
+		t.Errorf("skipempty got %q, want %q", params.SkipEmpty, want)
+	}
+}
+
+func TestDecoderIndexedSlice(t *testing.T) {
+	type Params struct {
+		Items   []string `json:"items"`
+		Limited []string `json:"limited,max=2"`
+	}
+	testCases := []struct {
+		desc    string
+		query   string
+		decoder form.Decoder
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc:  "in order",
+			query: "items[0]=a&items[1]=b",
+			want:  []string{"a", "b"},
+		},
+		{
+			desc:  "sparse",
+			query: "items[2]=c&items[0]=a",
+			want:  []string{"a", "", "c"},
+		},
+		{
+			desc:    "default max index exceeded",
+			query:   "items[1000000]=x",
+			wantErr: true,
+		},
+		{
+			desc:    "configured max index",
+			query:   "items[3]=x",
+			decoder: form.Decoder{MaxSliceIndex: 3},
+			want:    []string{"", "", "", "x"},
+		},
+		{
+			desc:    "configured max index exceeded",
+			query:   "items[4]=x",
+			decoder: form.Decoder{MaxSliceIndex: 3},
+			wantErr: true,
+		},
+		{
+			desc:    "negative index",
+			query:   "items[-1]=x",
+			wantErr: true,
+		},
+		{
+			desc:    "max slice len",
+			query:   "items[1]=b&items[0]=a",
+			decoder: form.Decoder{MaxSliceLen: 2},
+			want:    []string{"a", "b"},
+		},
+		{
+			desc:    "max slice len exceeded",
+			query:   "items[500]=x",
+			decoder: form.Decoder{MaxSliceLen: 2},
+			wantErr: true,
+		},
+		{
+			desc:    "max slice len truncated",
+			query:   "items[500]=x&items[0]=a",
+			decoder: form.Decoder{MaxSliceLen: 2, TruncateSlices: true},
+			want:    []string{"a"},
+		},
+		{
+			desc:    "max option exceeded",
+			query:   "limited[2]=x",
+			wantErr: true,
+		},
+		{
+			desc:  "plain then indexed",
+			query: "items[2]=c&items=a&items=b",
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			desc:  "empty index appends",
+			query: "items[]=a&items[]=b",
+			want:  []string{"a", "b"},
+		},
+		{
+			desc:  "empty index after indexed",
+			query: "items[]=c&items[1]=b&items=a",
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			desc:    "empty index max slice len exceeded",
+			query:   "items[]=a&items[]=b&items[]=c",
+			decoder: form.Decoder{MaxSliceLen: 2},
+			wantErr: true,
+		},
+		{
+			desc:    "empty index max slice len truncated",
+			query:   "items[]=a&items[]=b&items[]=c",
+			decoder: form.Decoder{MaxSliceLen: 2, TruncateSlices: true},
+			want:    []string{"a", "b"},
+		},
+		{
+			desc:  "plain then sparse indexed",
+			query: "items=a&items[2]=b",
+			want:  []string{"a", "", "b"},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := c.decoder.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query, err, c.wantErr)
+			}
+			if !c.wantErr && !reflect.DeepEqual(params.Items, c.want) {
+				t.Errorf("Unpack(%s) = %q, want %q", c.query, params.Items, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackUnescapeHTML(t *testing.T) {
+	var params struct {
+		Comment  string   `json:"comment,unhtml"`
+		Comments []string `json:"comments,unhtml"`
+		Raw      string   `json:"raw"`
+	}
+	q := url.Values{
+		"comment":  {"Tom &amp; Jerry&#39;s"},
+		"comments": {"&lt;b&gt;", "a &amp;&amp; b"},
+		"raw":      {"Tom &amp; Jerry"},
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?"+q.Encode(), nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack: %+v", err)
+	}
+	if want := "Tom & Jerry's"; params.Comment != want {
+		t.Errorf("comment got %q, want %q", params.Comment, want)
+	}
+	if want := []string{"<b>", "a && b"}; !reflect.DeepEqual(params.Comments, want) {
+		t.Errorf("comments got %q, want %q", params.Comments, want)
+	}
+	if want := "Tom &amp; Jerry"; params.Raw != want {
+		t.Errorf("raw got %q, want %q", params.Raw, want)
+	}
+}
+
+// point is a binary serialized type of 4 bytes.
+type point struct{ X, Y uint16 }
+
+func (p *point) UnmarshalBinary(b []byte) error {
+	if len(b) != 4 {
+		return fmt.Errorf("point needs 4 bytes, got %d", len(b))
+	}
+	p.X, p.Y = binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:])
+	return nil
+}
+
+func TestUnpackBinaryUnmarshaler(t *testing.T) {
+	type Params struct {
+		Base64 point   `json:"base64,encoding=base64"`
+		Hex    point   `json:"hex,encoding=hex"`
+		Points []point `json:"points,encoding=hex"`
+	}
+	testCases := []struct {
+		desc    string
+		query   url.Values
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc:  "base64 and hex",
+			query: url.Values{"base64": {base64.StdEncoding.EncodeToString([]byte{0, 1, 0, 2})}, "hex": {"00030004"}},
+			want:  Params{Base64: point{1, 2}, Hex: point{3, 4}},
+		},
+		{
+			desc:  "slice",
+			query: url.Values{"points": {"00010002", "00030004"}},
+			want:  Params{Points: []point{{1, 2}, {3, 4}}},
+		},
+		{
+			desc:    "malformed base64",
+			query:   url.Values{"base64": {"!!"}},
+			wantErr: true,
+		},
+		{
+			desc:    "rejected by UnmarshalBinary",
+			query:   url.Values{"hex": {"0001"}},
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query.Encode(), err, c.wantErr)
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) = %+v, want %+v", c.query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderMaxFormFields(t *testing.T) {
+	type Params struct {
+		Q     string `json:"q"`
+		Array []int  `json:"array"`
+	}
+	const boundary = "----WebKitFormBoundarykhWusB7Rx4ybHQtA"
+	multipartBody := func(n int) string {
+		var sb strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&sb, "--%s\r\nContent-Disposition: form-data; name=\"f%d\"\r\n\r\nv\r\n", boundary, i)
+		}
+		fmt.Fprintf(&sb, "--%s--\r\n", boundary)
+		return sb.String()
+	}
+	testCases := []struct {
+		desc        string
+		url         string
+		body        string
+		contentType string
+		option      form.Option
+		wantErr     bool
+	}{
+		{
+			desc:   "query within limit",
+			url:    "http://google.com?q=golang&array=1&array=2",
+			option: form.Query,
+		},
+		{
+			desc:    "query exceeded",
+			url:     "http://google.com?q=golang&array=1&array=2&array=3",
+			option:  form.Query,
+			wantErr: true,
+		},
+		{
+			desc:        "urlencoded body exceeded",
+			url:         "http://google.com",
+			body:        "a=1&b=2&c=3&d=4",
+			contentType: "application/x-www-form-urlencoded",
+			option:      form.Body,
+			wantErr:     true,
+		},
+		{
+			desc:        "multipart within limit",
+			url:         "http://google.com",
+			body:        multipartBody(3),
+			contentType: "multipart/form-data; boundary=" + boundary,
+			option:      form.Multipart,
+		},
+		{
+			desc:        "multipart exceeded",
+			url:         "http://google.com",
+			body:        multipartBody(4),
+			contentType: "multipart/form-data; boundary=" + boundary,
+			option:      form.Multipart,
+			wantErr:     true,
+		},
+	}
+	d := form.Decoder{MaxFormFields: 3}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, c.url, strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			var params Params
+			if err := d.Unpack(req, &params, c.option); (err != nil) != c.wantErr {
+				t.Errorf("Unpack err = %v, want err %t", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecoderIntBool(t *testing.T) {
+	type Params struct {
+		Active  bool `json:"active"`
+		Lenient bool `json:"lenient,intbool=lenient"`
+		Strict  bool `json:"strict,intbool=strict"`
+	}
+	testCases := []struct {
+		desc    string
+		query   string
+		decoder form.Decoder
+		want    Params
+		wantErr bool
+	}{
+		{desc: "strict 0", query: "active=0", want: Params{Active: false}},
+		{desc: "strict 1", query: "active=1", want: Params{Active: true}},
+		{desc: "strict 2", query: "active=2", wantErr: true},
+		{desc: "lenient 0", query: "active=0", decoder: form.Decoder{IntBool: form.IntBoolLenient}, want: Params{Active: false}},
+		{desc: "lenient 1", query: "active=1", decoder: form.Decoder{IntBool: form.IntBoolLenient}, want: Params{Active: true}},
+		{desc: "lenient 2", query: "active=2", decoder: form.Decoder{IntBool: form.IntBoolLenient}, want: Params{Active: true}},
+		{desc: "tag lenient 2", query: "lenient=2", want: Params{Lenient: true}},
+		{desc: "tag strict 2", query: "strict=2", decoder: form.Decoder{IntBool: form.IntBoolLenient}, wantErr: true},
+		{desc: "non integer", query: "active=true&lenient=false", want: Params{Active: true}},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := c.decoder.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query, err, c.wantErr)
+			}
+			if !c.wantErr && params != c.want {
+				t.Errorf("Unpack(%s) = %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderEmptyArrayElement(t *testing.T) {
+	type Params struct {
+		IDs  []int    `json:"ids"`
+		Tags []string `json:"tags"`
+	}
+	testCases := []struct {
+		desc    string
+		query   string
+		policy  form.EmptyElementPolicy
+		want    Params
+		wantErr bool
+	}{
+		{desc: "keep ints", query: "ids=&ids=5", policy: form.EmptyElementKeep, wantErr: true},
+		{desc: "keep strings", query: "tags=&tags=5", policy: form.EmptyElementKeep, want: Params{Tags: []string{"", "5"}}},
+		{desc: "drop", query: "ids=&ids=5&tags=&tags=5", policy: form.EmptyElementDrop, want: Params{IDs: []int{5}, Tags: []string{"5"}}},
+		{desc: "error", query: "ids=&ids=5", policy: form.EmptyElementError, wantErr: true},
+		{desc: "error without empty", query: "ids=5", policy: form.EmptyElementError, want: Params{IDs: []int{5}}},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			d := form.Decoder{EmptyArrayElement: c.policy}
+			if err := d.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query, err, c.wantErr)
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) = %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackTimeLocation(t *testing.T) {
+	var params struct {
+		UTC      time.Time   `json:"utc"`
+		NewYork  time.Time   `json:"ny" time_location:"America/New_York"`
+		Dates    []time.Time `json:"dates" time_location:"America/New_York"`
+		WithZone time.Time   `json:"zone" time_location:"America/New_York"`
+		Format   time.Time   `json:"format" time_format:"02/01/2006"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?utc=2023-06-01&ny=2023-06-01&dates=2023-06-01&zone=2023-06-01T00:00:00Z&format=01/06/2023", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack: %+v", err)
+		return
+	}
+	utc := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	if !params.UTC.Equal(utc) || params.UTC.Location() != time.UTC {
+		t.Errorf("utc got %v, want %v", params.UTC, utc)
+	}
+	// EDT is UTC-4 in June.
+	if want := utc.Add(4 * time.Hour); !params.NewYork.Equal(want) || params.NewYork.Location().String() != "America/New_York" {
+		t.Errorf("ny got %v, want %v", params.NewYork, want)
+	}
+	if len(params.Dates) != 1 || !params.Dates[0].Equal(params.NewYork) {
+		t.Errorf("dates got %v, want [%v]", params.Dates, params.NewYork)
+	}
+	if !params.WithZone.Equal(utc) {
+		t.Errorf("zone got %v, want %v", params.WithZone, utc)
+	}
+	if !params.Format.Equal(utc) {
+		t.Errorf("format got %v, want %v", params.Format, utc)
+	}
+}
+
+func TestDecoderMultipartJSON(t *testing.T) {
+	type Params struct {
+		Title  string                `json:"title"`
+		Tags   []string              `json:"tags"`
+		Public bool                  `json:"public"`
+		File   *multipart.FileHeader `json:"file"`
+	}
+	body := `------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="json"
+Content-Type: application/json
+
+{"title": "hello", "tags": ["a", "b"], "public": false}
+------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="public"
+
+true
+------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="file"; filename="hello.txt"
+Content-Type: text/plain
+
+hello, world
+
+------WebKitFormBoundarykhWusB7Rx4ybHQtA--`
+	r, err := http.NewRequest(http.MethodPost, "https://google.com/", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request fail: %+v", err)
+		return
+	}
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundarykhWusB7Rx4ybHQtA")
+	var params Params
+	d := form.Decoder{MultipartJSON: true}
+	if err := d.Unpack(r, &params, form.Multipart); err != nil {
+		t.Errorf("Unpack: %+v", err)
+		return
+	}
+	if params.Title != "hello" || !reflect.DeepEqual(params.Tags, []string{"a", "b"}) || !params.Public {
+		t.Errorf("got %+v", params)
+	}
+	want := &multipart.FileHeader{Filename: "hello.txt", Size: int64(len("hello, world\n"))}
+	if params.File == nil || !comparePart(params.File, want) {
+		t.Errorf("file got %+v, want %+v", params.File, want)
+	}
+}
+
+func TestUnpackFactory(t *testing.T) {
+	form.RegisterFactory("slugify", func(value string) (interface{}, error) {
+		if value == "" {
+			return nil, errors.New("empty slug")
+		}
+		return strings.ToLower(strings.Join(strings.Fields(value), "-")), nil
+	})
+	form.RegisterFactory("length", func(value string) (interface{}, error) {
+		return len(value), nil
+	})
+	type Params struct {
+		Slug   string   `json:"slug,factory=slugify"`
+		Slugs  []string `json:"slugs,factory=slugify"`
+		Length int      `json:"length,factory=length"`
+		Bad    string   `json:"bad,factory=length"`
+	}
+	testCases := []struct {
+		desc    string
+		query   url.Values
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc:  "ok",
+			query: url.Values{"slug": {"Hello  Golang World"}, "slugs": {"A B", "C"}, "length": {"hello"}},
+			want:  Params{Slug: "hello-golang-world", Slugs: []string{"a-b", "c"}, Length: 5},
+		},
+		{
+			desc:    "factory error",
+			query:   url.Values{"slug": {""}},
+			wantErr: true,
+		},
+		{
+			desc:    "type mismatch",
+			query:   url.Values{"bad": {"hello"}},
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query.Encode(), err, c.wantErr)
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) = %+v, want %+v", c.query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackPrefixedMap(t *testing.T) {
+	var params struct {
+		Q       string            `json:"q"`
+		Filters map[string]string `json:"filter_*"`
+		Counts  map[string]int    `json:"count_*"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?q=golang&filter_name=x&filter_status=y&filter_=z&count_a=1&count_b=2", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack: %+v", err)
+		return
+	}
+	if params.Q != "golang" {
+		t.Errorf("q got %q, want golang", params.Q)
+	}
+	if want := map[string]string{"name": "x", "status": "y"}; !reflect.DeepEqual(params.Filters, want) {
+		t.Errorf("filters got %v, want %v", params.Filters, want)
+	}
+	if want := map[string]int{"a": 1, "b": 2}; !reflect.DeepEqual(params.Counts, want) {
+		t.Errorf("counts got %v, want %v", params.Counts, want)
+	}
+}
+
+func TestUnpackMap(t *testing.T) {
+	type Params struct {
+		Meta   map[string]string   `json:"meta"`
+		Labels map[string][]string `json:"labels"`
+		Limits map[string]int      `json:"limits"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{query: "meta[foo]=bar&meta[baz]=qux", want: Params{Meta: map[string]string{"foo": "bar", "baz": "qux"}}},
+		{query: "meta.foo=bar&meta.baz=qux", want: Params{Meta: map[string]string{"foo": "bar", "baz": "qux"}}},
+		{query: "meta[foo]=bar&meta.baz=qux&meta[x=y&meta[]=z&meta=w", want: Params{Meta: map[string]string{"foo": "bar", "baz": "qux"}}},
+		{query: "labels[env]=prod&labels[env]=staging&labels.team=go", want: Params{Labels: map[string][]string{"env": {"prod", "staging"}, "team": {"go"}}}},
+		{query: "limits[cpu]=2&limits.memory=512", want: Params{Limits: map[string]int{"cpu": 2, "memory": 512}}},
+		{query: "limits[cpu]=x", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackIntBase(t *testing.T) {
+	type Params struct {
+		Reg  uint16   `json:"reg,base=16"`
+		Regs []uint16 `json:"regs,base=16"`
+		Mode int      `json:"mode,base=8"`
+	}
+	testCases := []struct {
+		desc    string
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{desc: "hex", query: "reg=1A2B", want: Params{Reg: 0x1A2B}},
+		{desc: "hex lower case", query: "reg=1a2b", want: Params{Reg: 0x1A2B}},
+		{desc: "hex at width limit", query: "reg=FFFF", want: Params{Reg: 0xFFFF}},
+		{desc: "hex above width limit", query: "reg=10000", wantErr: true},
+		{desc: "hex slice", query: "regs=FF&regs=1A2B", want: Params{Regs: []uint16{0xFF, 0x1A2B}}},
+		{desc: "hex slice above width limit", query: "regs=FF&regs=1FFFF", wantErr: true},
+		{desc: "octal", query: "mode=755", want: Params{Mode: 0755}},
+		{desc: "invalid digit", query: "reg=XYZ", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want err %t", c.query, err, c.wantErr)
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) = %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderOnBind(t *testing.T) {
+	type Params struct {
+		Q    string `json:"q"`
+		Page int    `json:"page"`
+	}
+	var bound []interface{}
+	d := form.Decoder{
+		OnBind: func(r *http.Request, ptr interface{}) {
+			bound = append(bound, *ptr.(*Params))
+		},
+		Validators: map[string]func(string, reflect.Value) error{
+			"page": func(name string, v reflect.Value) error {
+				if v.Int() < 1 {
+					return errors.New("out of range")
+				}
+				return nil
+			},
+		},
+	}
+	for _, query := range []string{"q=golang&page=1", "q=golang&page=bad", "q=golang&page=0"} {
+		req, err := http.NewRequest(http.MethodGet, "http://google.com?"+query, nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		var params Params
+		d.Unpack(req, &params, form.Query)
+	}
+	if want := []interface{}{Params{Q: "golang", Page: 1}}; !reflect.DeepEqual(bound, want) {
+		t.Errorf("OnBind got %+v, want %+v", bound, want)
+	}
+}
+
+func TestUnpackSecret(t *testing.T) {
+	type Params struct {
+		PIN    int            `json:"pin,secret"`
+		PINs   []int          `json:"pins,secret"`
+		Public int            `json:"public"`
+		Opts   map[string]int `json:"opts,kvlist,secret"`
+	}
+	const secret = "s3cr3t-v4lue"
+	testCases := []struct {
+		desc       string
+		query      string
+		secret     string
+		wantSecret bool
+	}{
+		{desc: "secret", query: "pin=" + secret},
+		{desc: "secret slice", query: "pins=1&pins=" + secret},
+		{desc: "public", query: "public=" + secret, wantSecret: true},
+		{desc: "quoted", query: "pin=" + url.QueryEscape(`hun"ter2`), secret: "ter2"},
+		{desc: "non-printable", query: "pin=" + url.QueryEscape("pässwörd\x01"), secret: "rd"},
+		{desc: "kvlist", query: "opts=" + url.QueryEscape("a=1,hunter2"), secret: "hunter2"},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			secret := secret
+			if c.secret != "" {
+				secret = c.secret
+			}
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			err = form.UnpackWithOption(req, &params, form.Query)
+			if err == nil {
+				t.Errorf("Unpack(%s) err = nil, want error", c.query)
+				return
+			}
+			if got := strings.Contains(err.Error(), secret); got != c.wantSecret {
+				t.Errorf("Unpack(%s) err = %q contains secret %t, want %t", c.query, err, got, c.wantSecret)
+			}
+			if got := (form.MultiError{err}).Error(); strings.Contains(got, secret) != c.wantSecret {
+				t.Errorf("MultiError %q contains secret, want %t", got, c.wantSecret)
+			}
+		})
+	}
+}
+
+func TestUnpackRequiredFor(t *testing.T) {
+	type Params struct {
+		Version int    `json:"version,requiredfor=PUT,PATCH"`
+		Name    string `json:"name"`
+	}
+	testCases := []struct {
+		method  string
+		body    string
+		wantErr bool
+	}{
+		{method: http.MethodPut, body: "name=x", wantErr: true},
+		{method: http.MethodPatch, body: "name=x", wantErr: true},
+		{method: http.MethodPost, body: "name=x"},
+		{method: http.MethodPut, body: "name=x&version=2"},
+	}
+	for _, c := range testCases {
+		t.Run(c.method+" "+c.body, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			var params Params
+			err = form.UnpackWithOption(req, &params, form.Body)
+			if (err != nil) != c.wantErr {
+				t.Errorf("%s Unpack(%s) err = %v, want error %t", c.method, c.body, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnpackRequired(t *testing.T) {
+	type Params struct {
+		Q    string   `json:"q,required"`
+		Tags []string `json:"tags,required"`
+		Page int      `json:"page,required,allowdefault"`
+		Size int      `json:"size,required"`
+	}
+	testCases := []struct {
+		query     string
+		wantField string
+	}{
+		{query: "q=go&tags=a&size=10"},
+		{query: "q=&tags=a&size=10"},
+		{query: "q=go&tags[0]=a&size=10"},
+		{query: "tags=a&size=10", wantField: "q"},
+		{query: "q=go&size=10", wantField: "tags"},
+		{query: "q=go&tags=a", wantField: "size"},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			params := Params{Page: 1, Size: 20} // the defaults
+			err = form.UnpackWithOption(req, &params, form.Query)
+			if (err != nil) != (c.wantField != "") {
+				t.Errorf("Unpack(%s) err = %v, want error for %q", c.query, err, c.wantField)
+				return
+			}
+			if err == nil {
+				return
+			}
+			var fe *form.FieldError
+			if errs, ok := err.(form.MultiError); !ok || len(errs) != 1 || !errors.As(errs[0], &fe) || fe.Field != c.wantField {
+				t.Errorf("Unpack(%s) err = %v, want a FieldError of %s", c.query, err, c.wantField)
+			}
+		})
+	}
+}
+
+func TestUnpackDefault(t *testing.T) {
+	type Params struct {
+		Page   int      `json:"page,default=1"`
+		Sort   string   `json:"sort,default=created,desc"`
+		Active bool     `json:"active,default=true"`
+		Tags   []string `json:"tags,default=a,b,omitempty"`
+		Size   int      `json:"size,default=10,required"`
+		Limit  int      `json:"limit,default=20,required,allowdefault"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{query: "size=5", want: Params{Page: 1, Sort: "created,desc", Active: true, Tags: []string{"a", "b"}, Size: 5, Limit: 20}},
+		{query: "size=5&page=3&sort=name&active=false&tags=x", want: Params{Page: 3, Sort: "name", Active: false, Tags: []string{"x"}, Size: 5, Limit: 20}},
+		{query: "size=5&page=", wantErr: true},
+		{query: "", wantErr: true}, // required size is not satisfied by its default
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "http://google.com", nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		var params struct {
+			Page int `json:"page,default=x"`
+		}
+		var fe *form.FieldError
+		if err := form.UnpackWithOption(req, &params, form.Query); !errors.As(err, &fe) || fe.Field != "page" {
+			t.Errorf("Unpack err = %v, want a FieldError of page", err)
+		}
+	})
+}
+
+func TestUnpackRat(t *testing.T) {
+	type Params struct {
+		Ratio big.Rat `json:"ratio"`
+	}
+	testCases := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "ratio=3/4", want: "3/4"},
+		{query: "ratio=0.75", want: "3/4"},
+		{query: "ratio=-2", want: "-2/1"},
+		{query: "ratio=3/0", wantErr: true},
+		{query: "ratio=x", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			err = form.UnpackWithOption(req, &params, form.Query)
+			if c.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "ratio") {
+					t.Errorf("Unpack(%s) err = %v, want error of ratio", c.query, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack(%s) err: %+v", c.query, err)
+				return
+			}
+			if got := params.Ratio.String(); got != c.want {
+				t.Errorf("Unpack(%s) got %s, want %s", c.query, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderMaxPartSize(t *testing.T) {
+	type Params struct {
+		Name string `json:"name"`
+		Blob string `json:"blob"`
+	}
+	body := strings.Replace(`--boundary
+Content-Disposition: form-data; name="name"
+
+gopher
+--boundary
+Content-Disposition: form-data; name="blob"; filename="blob.bin"
+Content-Type: application/octet-stream
+
+0123456789
+--boundary--
+`, "\n", "\r\n", -1)
+	testCases := []struct {
+		desc        string
+		maxPartSize int64
+		want        Params
+		wantErr     bool
+	}{
+		{desc: "no limit", want: Params{Name: "gopher", Blob: "0123456789"}},
+		{desc: "within limit", maxPartSize: 10, want: Params{Name: "gopher", Blob: "0123456789"}},
+		{desc: "oversized part", maxPartSize: 9, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+			d := form.Decoder{MaxPartSize: c.maxPartSize}
+			var params Params
+			err = d.Unpack(req, &params, form.MultipartStream)
+			if c.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "blob") {
+					t.Errorf("Unpack err = %v, want error of blob", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack err: %+v", err)
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unpack got %+v, want %+v", params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackMultipartStreamFiles(t *testing.T) {
+	type Params struct {
+		Name   string                  `json:"name"`
+		Avatar *multipart.FileHeader   `json:"avatar"`
+		Docs   []*multipart.FileHeader `json:"docs"`
+		Notes  string                  `json:"notes"`
+	}
+	body := strings.Replace(`--boundary
+Content-Disposition: form-data; name="name"
+
+gopher
+--boundary
+Content-Disposition: form-data; name="avatar"; filename="avatar.png"
+Content-Type: image/png
+
+0123456789
+--boundary
+Content-Disposition: form-data; name="docs"; filename="a.txt"
+Content-Type: text/plain
+
+hello
+--boundary
+Content-Disposition: form-data; name="docs"; filename="b.txt"
+Content-Type: text/plain
+
+world
+--boundary
+Content-Disposition: form-data; name="notes"; filename="notes.txt"
+Content-Type: text/plain
+
+as text
+--boundary--
+`, "\n", "\r\n", -1)
+	testCases := []struct {
+		desc        string
+		maxMemory   int64
+		maxPartSize int64
+		wantErr     bool
+	}{
+		{desc: "in memory"},
+		{desc: "temporary files", maxMemory: 1},
+		{desc: "oversized file part", maxPartSize: 9, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+			d := form.Decoder{MaxMemory: c.maxMemory, MaxPartSize: c.maxPartSize}
+			var params Params
+			err = d.Unpack(req, &params, form.MultipartStream)
+			if req.MultipartForm != nil {
+				defer req.MultipartForm.RemoveAll()
+			}
+			if c.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "avatar") {
+					t.Errorf("Unpack err = %v, want error of avatar", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack err: %+v", err)
+				return
+			}
+			if params.Name != "gopher" || params.Notes != "as text" {
+				t.Errorf("Unpack got name %q, notes %q, want gopher, as text", params.Name, params.Notes)
+			}
+			files := []*multipart.FileHeader{params.Avatar}
+			files = append(files, params.Docs...)
+			want := []string{"avatar.png:0123456789", "a.txt:hello", "b.txt:world"}
+			if len(files) != len(want) || files[0] == nil {
+				t.Errorf("Unpack got avatar %v, docs %v, want %v", params.Avatar, params.Docs, want)
+				return
+			}
+			for i, fh := range files {
+				f, err := fh.Open()
+				if err != nil {
+					t.Errorf("open %s: %+v", fh.Filename, err)
+					return
+				}
+				b, err := ioutil.ReadAll(f)
+				f.Close()
+				if err != nil {
+					t.Errorf("read %s: %+v", fh.Filename, err)
+					return
+				}
+				if got := fh.Filename + ":" + string(b); got != want[i] {
+					t.Errorf("Unpack got file %s, want %s", got, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackURLComponents(t *testing.T) {
+	type Params struct {
+		Host     string `url:"host"`
+		Hostname string `url:"hostname"`
+		Port     int    `url:"port"`
+		Scheme   string `url:"scheme"`
+		Path     string `url:"path"`
+		Q        string `json:"q"`
+	}
+	testCases := []struct {
+		desc string
+		req  *http.Request
+		want Params
+	}{
+		{
+			desc: "server request",
+			req:  httptest.NewRequest(http.MethodGet, "/tenants/acme?q=golang", nil),
+			want: Params{Host: "example.com", Hostname: "example.com", Scheme: "http", Path: "/tenants/acme", Q: "golang"},
+		},
+		{
+			desc: "absolute URL",
+			req:  httptest.NewRequest(http.MethodGet, "https://acme.example.com:8443/v1/users?q=golang", nil),
+			want: Params{Host: "acme.example.com:8443", Hostname: "acme.example.com", Port: 8443, Scheme: "https", Path: "/v1/users", Q: "golang"},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			var params Params
+			if err := form.UnpackWithOption(c.req, &params, form.Query); err != nil {
+				t.Errorf("Unpack err: %+v", err)
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unpack got %+v, want %+v", params, c.want)
+			}
+		})
+	}
+
+	var invalid struct {
+		Fragment string `url:"fragment"`
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := form.UnpackWithOption(req, &invalid, form.Query); err == nil {
+		t.Errorf("Unpack unknown url component err = nil, want error")
+	}
+}
+
+func TestUnpackLines(t *testing.T) {
+	type Params struct {
+		Lines     []string `json:"lines,lines"`
+		SkipEmpty []int    `json:"ids,lines,skipempty"`
+		Plain     []string `json:"plain"`
+	}
+	testCases := []struct {
+		desc  string
+		query url.Values
+		want  Params
+	}{
+		{
+			desc:  "newline",
+			query: url.Values{"lines": {"a\nb\nc"}},
+			want:  Params{Lines: []string{"a", "b", "c"}},
+		},
+		{
+			desc:  "crlf",
+			query: url.Values{"lines": {"a\r\nb"}, "plain": {"a\r\nb"}},
+			want:  Params{Lines: []string{"a", "b"}, Plain: []string{"a\r\nb"}},
+		},
+		{
+			desc:  "repeated keys",
+			query: url.Values{"lines": {"a\nb", "c"}},
+			want:  Params{Lines: []string{"a", "b", "c"}},
+		},
+		{
+			desc:  "skip empty lines",
+			query: url.Values{"ids": {"1\n\n2\n"}},
+			want:  Params{SkipEmpty: []int{1, 2}},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+				t.Errorf("Unpack(%s) err: %+v", c.query.Encode(), err)
+				return
+			}
+			if !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackIntWidths(t *testing.T) {
+	type Params struct {
+		I8  int8  `json:"i8"`
+		I16 int16 `json:"i16"`
+		I32 int32 `json:"i32"`
+		I64 int64 `json:"i64"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr string
+	}{
+		{query: "i8=127&i16=32767&i32=2147483647&i64=9223372036854775807", want: Params{I8: math.MaxInt8, I16: math.MaxInt16, I32: math.MaxInt32, I64: math.MaxInt64}},
+		{query: "i8=-128&i16=-32768&i32=-2147483648&i64=-9223372036854775808", want: Params{I8: math.MinInt8, I16: math.MinInt16, I32: math.MinInt32, I64: math.MinInt64}},
+		{query: "i64=9007199254740993", want: Params{I64: 9007199254740993}},
+		{query: "i8=128", wantErr: "i8"},
+		{query: "i16=-32769", wantErr: "i16"},
+		{query: "i32=2147483648", wantErr: "i32"},
+		{query: "i64=9223372036854775808", wantErr: "i64"},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			err = form.UnpackWithOption(req, &params, form.Query)
+			if c.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), c.wantErr+":") {
+					t.Errorf("Unpack(%s) err = %v, want error of %s", c.query, err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack(%s) err: %+v", c.query, err)
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackHeader(t *testing.T) {
+	type Params struct {
+		Protocols []string `header:"Sec-WebSocket-Protocol"`
+		Version   int      `header:"sec-websocket-version"`
+		IDs       []int    `header:"X-Ids"`
+		Q         string   `json:"q"`
+	}
+	testCases := []struct {
+		desc    string
+		header  http.Header
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc:   "comma separated",
+			header: http.Header{"Sec-Websocket-Protocol": {"chat, superchat"}, "Sec-Websocket-Version": {"13"}},
+			want:   Params{Protocols: []string{"chat", "superchat"}, Version: 13, Q: "golang"},
+		},
+		{
+			desc:   "multi-valued",
+			header: http.Header{"Sec-Websocket-Protocol": {"chat", "v2.chat, superchat"}, "X-Ids": {"1,2", "3"}},
+			want:   Params{Protocols: []string{"chat", "v2.chat", "superchat"}, IDs: []int{1, 2, 3}, Q: "golang"},
+		},
+		{
+			desc: "absent",
+			want: Params{Q: "golang"},
+		},
+		{
+			desc:    "invalid",
+			header:  http.Header{"X-Ids": {"1,x"}},
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?q=golang", nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header = c.header
+			if req.Header == nil {
+				req.Header = make(http.Header)
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack err = %v, want error %t", err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack got %+v, want %+v", params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackUintWidths(t *testing.T) {
+	type Params struct {
+		Limit  uint32   `json:"limit"`
+		Offset uint64   `json:"offset"`
+		U      uint     `json:"u"`
+		U8     uint8    `json:"u8"`
+		Ports  []uint16 `json:"ports"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr string
+	}{
+		{query: "limit=20&offset=18446744073709551615", want: Params{Limit: 20, Offset: math.MaxUint64}},
+		{query: "u=0&u8=255&limit=4294967295", want: Params{U8: math.MaxUint8, Limit: math.MaxUint32}},
+		{query: "ports=80&ports=65535", want: Params{Ports: []uint16{80, 65535}}},
+		{query: "u8=256", wantErr: "u8"},
+		{query: "limit=-1", wantErr: "limit"},
+		{query: "ports=80&ports=65536", wantErr: "ports"},
+		{query: "offset=18446744073709551616", wantErr: "offset"},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			err = form.UnpackWithOption(req, &params, form.Query)
+			if c.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), c.wantErr+":") {
+					t.Errorf("Unpack(%s) err = %v, want error of %s", c.query, err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack(%s) err: %+v", c.query, err)
+				return
+			}
+			if !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackFloat32(t *testing.T) {
+	type Params struct {
+		Lat float32 `json:"lat"`
+		Lng float32 `json:"lng"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{query: "lat=31.2304&lng=121.4737", want: Params{Lat: 31.2304, Lng: 121.4737}},
+		{query: "lat=-90&lng=3.4028234e38", want: Params{Lat: -90, Lng: math.MaxFloat32}},
+		{query: "lat=3.5e38", wantErr: true},
+		{query: "lng=-1e39", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && params != c.want {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackRequestMeta(t *testing.T) {
+	type Audit struct {
+		Method     string `meta:"method"`
+		RemoteAddr string `meta:"remote_addr"`
+		Proto      string `meta:"proto"`
+		TLS        bool   `meta:"tls"`
+		Q          string `json:"q"`
+	}
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/search?q=golang", strings.NewReader("q=gopher"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "203.0.113.7:52100"
+	var audit Audit
+	if err := form.Unpack(req, &audit); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	want := Audit{Method: http.MethodPost, RemoteAddr: "203.0.113.7:52100", Proto: "HTTP/1.1", TLS: true, Q: "gopher"}
+	if audit != want {
+		t.Errorf("Unpack got %+v, want %+v", audit, want)
+	}
+
+	var invalid struct {
+		User string `meta:"user"`
+	}
+	if err := form.UnpackWithOption(req, &invalid, form.Query); err == nil {
+		t.Errorf("Unpack unknown request meta err = nil, want error")
+	}
+}
+
+func TestUnpackTimeLayout(t *testing.T) {
+	type Params struct {
+		From  time.Time `json:"from"`
+		Day   time.Time `json:"day,layout=2006-01-02"`
+		Epoch time.Time `json:"epoch,layout=unix"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr string
+	}{
+		{query: "from=2023-01-02T15:04:05Z", want: Params{From: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)}},
+		{query: "day=2023-01-02", want: Params{Day: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		{query: "epoch=1672671845", want: Params{Epoch: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)}},
+		{query: "day=02/01/2023", wantErr: "day"},
+		{query: "epoch=2023-01-02", wantErr: "epoch"},
+		{query: "from=yesterday", wantErr: "from"},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			err = form.UnpackWithOption(req, &params, form.Query)
+			if c.wantErr != "" {
+				value := c.query[strings.IndexByte(c.query, '=')+1:]
+				if err == nil || !strings.HasPrefix(err.Error(), c.wantErr+":") || !strings.Contains(err.Error(), value) {
+					t.Errorf("Unpack(%s) err = %v, want error of %s with the value", c.query, err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack(%s) err: %+v", c.query, err)
+				return
+			}
+			if !params.From.Equal(c.want.From) || !params.Day.Equal(c.want.Day) || !params.Epoch.Equal(c.want.Epoch) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackMaxLen(t *testing.T) {
+	type Params struct {
+		Bio    string   `json:"bio,maxlen=5"`
+		Strict string   `json:"strict,maxlen=5,strictlen"`
+		Tags   []string `json:"tags,maxlen=2"`
+	}
+	testCases := []struct {
+		desc    string
+		query   url.Values
+		want    Params
+		wantErr bool
+	}{
+		{desc: "below", query: url.Values{"bio": {"abc"}, "strict": {"abc"}}, want: Params{Bio: "abc", Strict: "abc"}},
+		{desc: "at", query: url.Values{"bio": {"abcde"}, "strict": {"abcde"}}, want: Params{Bio: "abcde", Strict: "abcde"}},
+		{desc: "above truncated", query: url.Values{"bio": {"abcdefg"}}, want: Params{Bio: "abcde"}},
+		{desc: "multibyte truncated", query: url.Values{"bio": {"你好世界你好"}}, want: Params{Bio: "你好世界你"}},
+		{desc: "slice elements", query: url.Values{"tags": {"abc", "d"}}, want: Params{Tags: []string{"ab", "d"}}},
+		{desc: "above strict", query: url.Values{"strict": {"abcdef"}}, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query.Encode(), err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderFieldTiming(t *testing.T) {
+	var params struct {
+		Q    string    `json:"q"`
+		Page int       `json:"page"`
+		IDs  []int     `json:"ids"`
+		From time.Time `json:"from"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?q=golang&page=2&ids=1&ids=2&from=2023-01-02", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	timings := make(map[string]int)
+	d := form.Decoder{FieldTiming: func(name string, elapsed time.Duration) {
+		if elapsed < 0 {
+			t.Errorf("%s elapsed %v, want non-negative", name, elapsed)
+		}
+		timings[name]++
+	}}
+	if err := d.Unpack(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	if want := map[string]int{"q": 1, "page": 1, "ids": 2, "from": 1}; !reflect.DeepEqual(timings, want) {
+		t.Errorf("FieldTiming got %v, want %v", timings, want)
+	}
+}
+
+func TestUnpackPointer(t *testing.T) {
+	type Params struct {
+		Age   *int     `json:"age"`
+		Name  *string  `json:"name"`
+		Admin *bool    `json:"admin"`
+		Score *float64 `json:"score"`
+	}
+	intp := func(i int) *int { return &i }
+	stringp := func(s string) *string { return &s }
+	boolp := func(b bool) *bool { return &b }
+	float64p := func(f float64) *float64 { return &f }
+	testCases := []struct {
+		desc    string
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc:  "present",
+			query: "age=0&name=gopher&admin=false&score=0",
+			want:  Params{Age: intp(0), Name: stringp("gopher"), Admin: boolp(false), Score: float64p(0)},
+		},
+		{
+			desc:  "empty string",
+			query: "name=&admin",
+			want:  Params{Name: stringp(""), Admin: boolp(true)},
+		},
+		{
+			desc:  "omitted",
+			query: "q=golang",
+			want:  Params{},
+		},
+		{
+			desc:    "invalid",
+			query:   "age=",
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+// email is a validated email address.
+type email string
+
+func (e *email) UnmarshalText(text []byte) error {
+	if !strings.Contains(string(text), "@") {
+		return fmt.Errorf("invalid email %q", text)
+	}
+	*e = email(text)
+	return nil
+}
+
+func TestUnpackTextUnmarshaler(t *testing.T) {
+	type Params struct {
+		Email  email   `json:"email"`
+		CC     []email `json:"cc"`
+		IP     net.IP  `json:"ip"`
+		Backup *email  `json:"backup"`
+	}
+	backup := email("b@example.com")
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{
+			query: "email=a@example.com&cc=b@example.com&cc=c@example.com",
+			want:  Params{Email: "a@example.com", CC: []email{"b@example.com", "c@example.com"}},
+		},
+		{
+			query: "ip=192.0.2.1&backup=b@example.com",
+			want:  Params{IP: net.ParseIP("192.0.2.1"), Backup: &backup},
+		},
+		{query: "email=gopher", wantErr: true},
+		{query: "cc=a@example.com&cc=gopher", wantErr: true},
+		{query: "ip=192.0.2", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackReplace(t *testing.T) {
+	type Params struct {
+		Sort    []string `json:"sort,replace"`
+		Lines   []string `json:"lines,replace,lines"`
+		Tags    []string `json:"tags"`
+		Page    int      `json:"page"`
+		Default []string `json:"default,replace"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?sort=name&sort=-date&lines=a%0Ab&lines=c%0Ad&tags=a&tags=b&page=1&page=2&default=x", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	params := Params{Default: []string{"a", "b"}}
+	if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	want := Params{
+		Sort:    []string{"-date"},
+		Lines:   []string{"c", "d"},
+		Tags:    []string{"a", "b"},
+		Page:    2,
+		Default: []string{"x"},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("Unpack got %+v, want %+v", params, want)
+	}
+}
+
+func TestUnpackPartHeader(t *testing.T) {
+	type Params struct {
+		File      *multipart.FileHeader   `json:"file"`
+		Checksum  string                  `partheader:"file,x-checksum"`
+		Type      string                  `partheader:"file,Content-Type"`
+		Absent    string                  `partheader:"file,X-Absent"`
+		Chunks    []int                   `partheader:"chunks,X-Chunk-Offset"`
+		ChunkData []*multipart.FileHeader `json:"chunks"`
+	}
+	body := strings.Replace(`--boundary
+Content-Disposition: form-data; name="file"; filename="hello.txt"
+Content-Type: text/plain
+X-Checksum: sha256=abc
+
+hello, world
+--boundary
+Content-Disposition: form-data; name="chunks"; filename="0.bin"
+X-Chunk-Offset: 0
+
+01
+--boundary
+Content-Disposition: form-data; name="chunks"; filename="1.bin"
+X-Chunk-Offset: 2
+
+23
+--boundary--
+`, "\n", "\r\n", -1)
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	var params Params
+	if err := form.UnpackWithOption(req, &params, form.Multipart); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	if params.File == nil || params.Checksum != "sha256=abc" || params.Type != "text/plain" || params.Absent != "" {
+		t.Errorf("Unpack got %+v, want the file part headers", params)
+	}
+	if want := []int{0, 2}; !reflect.DeepEqual(params.Chunks, want) || len(params.ChunkData) != 2 {
+		t.Errorf("Unpack got chunks %v of %d parts, want %v", params.Chunks, len(params.ChunkData), want)
+	}
+
+	var invalid struct {
+		Checksum string `partheader:"file"`
+	}
+	if err := form.UnpackWithOption(req, &invalid, form.Multipart); err == nil {
+		t.Errorf("Unpack part header without key err = nil, want error")
+	}
+}
+
+func TestMultipartMaxMemory(t *testing.T) {
+	if want := int64(10 << 20); form.MultipartMaxMemory != want {
+		t.Errorf("MultipartMaxMemory = %d, want %d", form.MultipartMaxMemory, want)
+	}
+
+	// A 1MB file is kept in memory rather than spilled to a temporary file.
+	content := strings.Repeat("x", 1<<20)
+	body := strings.Replace(`--boundary
+Content-Disposition: form-data; name="file"; filename="big.txt"
+
+`, "\n", "\r\n", -1) + content + "\r\n--boundary--\r\n"
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	var params struct {
+		File *multipart.FileHeader `json:"file"`
+	}
+	if err := form.UnpackWithOption(req, &params, form.Multipart); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	f, err := params.File.Open()
+	if err != nil {
+		t.Errorf("open file: %+v", err)
+		return
+	}
+	defer f.Close()
+	if _, ok := f.(*os.File); ok {
+		t.Errorf("file of %d bytes spilled to disk, want in memory", params.File.Size)
+	}
+}
+
+func TestUnpackWithOptionsTag(t *testing.T) {
+	type Params struct {
+		Q string `json:"q" form:"query"`
+	}
+	testCases := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "", want: "json"},
+		{tag: "json", want: "json"},
+		{tag: "form", want: "form"},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, c := range testCases {
+			wg.Add(1)
+			go func(tag, want string) {
+				defer wg.Done()
+				req, err := http.NewRequest(http.MethodGet, "http://google.com?q=json&query=form", nil)
+				if err != nil {
+					t.Errorf("new request: %+v", err)
+					return
+				}
+				var params Params
+				if err := form.UnpackWithOptions(req, &params, form.Options{Tag: tag, Option: form.Query}); err != nil {
+					t.Errorf("Unpack with tag %q err: %+v", tag, err)
+					return
+				}
+				if params.Q != want {
+					t.Errorf("Unpack with tag %q got %s, want %s", tag, params.Q, want)
+				}
+			}(c.tag, c.want)
+		}
+	}
+	wg.Wait()
+}
+
+func TestDecoderBoolValues(t *testing.T) {
+	type Params struct {
+		Active bool   `json:"active"`
+		Flags  []bool `json:"flags"`
+	}
+	d := form.Decoder{TrueValues: []string{"Y"}, FalseValues: []string{"N"}}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{query: "active=Y", want: Params{Active: true}},
+		{query: "active=y&flags=N&flags=Y&flags=true", want: Params{Active: true, Flags: []bool{false, true, true}}},
+		{query: "active=false", want: Params{}},
+		{query: "active=X", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := d.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+
+	// The empty value is true by default, unless it's one of the FalseValues.
+	for _, c := range []struct {
+		d    form.Decoder
+		want bool
+	}{{form.Decoder{}, true}, {form.Decoder{FalseValues: []string{""}}, false}} {
+		req, err := http.NewRequest(http.MethodGet, "http://google.com?active=", nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		params := Params{Active: !c.want}
+		if err := c.d.Unpack(req, &params, form.Query); err != nil {
+			t.Errorf("Unpack(active=) with false values %q err: %+v", c.d.FalseValues, err)
+		} else if params.Active != c.want {
+			t.Errorf("Unpack(active=) with false values %q got %t, want %t", c.d.FalseValues, params.Active, c.want)
+		}
+	}
+}
+
+func TestUnpackResetSlices(t *testing.T) {
+	type Group struct {
+		Name string   `json:"name"`
+		IDs  []string `json:"ids"`
+	}
+	type Params struct {
+		Tags    []string `json:"tags"`
+		Default []int    `json:"default"`
+		Items   []string `json:"items"`
+		Groups  []Group  `json:"groups"`
+	}
+	params := Params{Default: []int{1, 2}}
+	for _, query := range []string{
+		"tags=a&tags=b&items[0]=x&items[1]=y&items[2]=z&groups[0].name=a&groups[1].name=b&groups[0].ids[0]=1&groups[0].ids[1]=2",
+		"tags=c&items[0]=a&groups[0].name=c&groups[0].ids[1]=3&groups[0].ids[0]=4",
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://google.com?"+query, nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+			t.Errorf("Unpack(%s) err: %+v", query, err)
+			return
+		}
+	}
+	want := Params{Tags: []string{"c"}, Default: []int{1, 2}, Items: []string{"a"}, Groups: []Group{{Name: "c", IDs: []string{"4", "3"}}}}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("Unpack twice got %+v, want %+v", params, want)
+	}
+
+	t.Run("files", func(t *testing.T) {
+		var files struct {
+			Files []*multipart.FileHeader `json:"files"`
+		}
+		for _, name := range []string{"a.txt", "b.txt"} {
+			var body strings.Builder
+			w := multipart.NewWriter(&body)
+			fw, _ := w.CreateFormFile("files", name)
+			fw.Write([]byte(name))
+			w.Close()
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body.String()))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", w.FormDataContentType())
+			if err := form.UnpackWithOption(req, &files, form.Multipart); err != nil {
+				t.Errorf("Unpack(%s) err: %+v", name, err)
+				return
+			}
+		}
+		if len(files.Files) != 1 || files.Files[0].Filename != "b.txt" {
+			t.Errorf("Unpack twice got %d files, want b.txt only", len(files.Files))
+		}
+	})
+}
+
+func TestUnpackKVList(t *testing.T) {
+	type Params struct {
+		Opts   map[string]string `json:"opts,kvlist"`
+		Limits map[string]int    `json:"limits,kvlist"`
+	}
+	testCases := []struct {
+		query   url.Values
+		want    Params
+		wantErr bool
+	}{
+		{
+			query: url.Values{"opts": {"color=red,size=large"}},
+			want:  Params{Opts: map[string]string{"color": "red", "size": "large"}},
+		},
+		{
+			query: url.Values{"opts": {"expr=a=b,empty="}, "limits": {"cpu=2,mem=512"}},
+			want:  Params{Opts: map[string]string{"expr": "a=b", "empty": ""}, Limits: map[string]int{"cpu": 2, "mem": 512}},
+		},
+		{query: url.Values{"opts": {"color=red,size"}}, wantErr: true},
+		{query: url.Values{"opts": {"=red"}}, wantErr: true},
+		{query: url.Values{"limits": {"cpu=x"}}, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query.Encode(), func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query.Encode(), err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderStrictSingleFile(t *testing.T) {
+	type Params struct {
+		File  *multipart.FileHeader   `json:"file"`
+		Files []*multipart.FileHeader `json:"files"`
+	}
+	part := func(name string) string {
+		return "--boundary\nContent-Disposition: form-data; name=\"" + name + "\"; filename=\"a.txt\"\n\nhello\n"
+	}
+	testCases := []struct {
+		desc    string
+		strict  bool
+		body    string
+		wantErr bool
+	}{
+		{desc: "single", strict: true, body: part("file") + part("files") + part("files")},
+		{desc: "multiple lenient", body: part("file") + part("file")},
+		{desc: "multiple strict", strict: true, body: part("file") + part("file"), wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			body := strings.Replace(c.body+"--boundary--\n", "\n", "\r\n", -1)
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+			d := form.Decoder{StrictSingleFile: c.strict}
+			var params Params
+			if err := d.Unpack(req, &params, form.Multipart); (err != nil) != c.wantErr {
+				t.Errorf("Unpack err = %v, want error %t", err, c.wantErr)
+				return
+			}
+			if !c.wantErr && params.File == nil {
+				t.Errorf("Unpack got no file, want one")
+			}
+		})
+	}
+}
+
+func TestUnpackNested(t *testing.T) {
+	type Geo struct {
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+	}
+	type Address struct {
+		City    string    `json:"city"`
+		Zip     string    `json:"zip"`
+		Geo     Geo       `json:"geo"`
+		Created time.Time `json:"created"`
+	}
+	type Params struct {
+		Home   Address `json:"address"`
+		ShipTo Address
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?address.city=NYC&address.zip=10001&address.geo.lat=40.7&address.geo.lng=-74&address.created=2023-01-02&shipTo.city=LA", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	var params Params
+	if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	want := Params{
+		Home: Address{
+			City:    "NYC",
+			Zip:     "10001",
+			Geo:     Geo{Lat: 40.7, Lng: -74},
+			Created: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		ShipTo: Address{City: "LA"},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("Unpack got %+v, want %+v", params, want)
+	}
+}
+
+// filter is a structured query of the syntax `status:active AND age>30`.
+type filter struct {
+	Conds []filterCond
+}
+
+type filterCond struct {
+	Field, Op, Value string
+}
+
+func parseFilter(value string) (interface{}, error) {
+	var f filter
+	for _, expr := range strings.Split(value, " AND ") {
+		i := strings.IndexAny(expr, ":<>")
+		if i <= 0 || i == len(expr)-1 {
+			return nil, fmt.Errorf("invalid filter %q", expr)
+		}
+		f.Conds = append(f.Conds, filterCond{Field: expr[:i], Op: expr[i : i+1], Value: expr[i+1:]})
+	}
+	return f, nil
+}
+
+func TestRegisterParser(t *testing.T) {
+	form.RegisterParser(reflect.TypeOf(filter{}), parseFilter)
+	type Params struct {
+		Filter  filter   `json:"filter"`
+		Filters []filter `json:"filters"`
+		Exclude *filter  `json:"exclude"`
+	}
+	testCases := []struct {
+		query   url.Values
+		want    Params
+		wantErr bool
+	}{
+		{
+			query: url.Values{"filter": {"status:active AND age>30"}},
+			want: Params{Filter: filter{Conds: []filterCond{
+				{Field: "status", Op: ":", Value: "active"},
+				{Field: "age", Op: ">", Value: "30"},
+			}}},
+		},
+		{
+			query: url.Values{"filters": {"a:1", "b<2"}, "exclude": {"status:deleted"}},
+			want: Params{
+				Filters: []filter{
+					{Conds: []filterCond{{Field: "a", Op: ":", Value: "1"}}},
+					{Conds: []filterCond{{Field: "b", Op: "<", Value: "2"}}},
+				},
+				Exclude: &filter{Conds: []filterCond{{Field: "status", Op: ":", Value: "deleted"}}},
+			},
+		},
+		{query: url.Values{"filter": {"status"}}, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query.Encode(), func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query.Encode(), err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderPreserveNonZero(t *testing.T) {
+	type Filter struct {
+		Owner  string `json:"owner"`
+		Status string `json:"status"`
+	}
+	type Params struct {
+		Q      string   `json:"q"`
+		Page   int      `json:"page"`
+		Tags   []string `json:"tags"`
+		Filter Filter   `json:"filter"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?q=golang&page=3&tags=a&filter.owner=eve&filter.status=open", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	testCases := []struct {
+		desc    string
+		decoder form.Decoder
+		want    Params
+	}{
+		{
+			desc: "overwrite",
+			want: Params{Q: "golang", Page: 3, Tags: []string{"a"}, Filter: Filter{Owner: "eve", Status: "open"}},
+		},
+		{
+			desc:    "preserve",
+			decoder: form.Decoder{PreserveNonZero: true},
+			want:    Params{Q: "golang", Page: 1, Tags: []string{"preset"}, Filter: Filter{Owner: "alice", Status: "open"}},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			params := Params{Page: 1, Tags: []string{"preset"}, Filter: Filter{Owner: "alice"}}
+			if err := c.decoder.Unpack(req, &params, form.Query); err != nil {
+				t.Errorf("Unpack err: %+v", err)
+				return
+			}
+			if !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack got %+v, want %+v", params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackCSV(t *testing.T) {
+	type Params struct {
+		Rows [][]int  `json:"rows,csv"`
+		IDs  []int    `json:"ids,csv"`
+		Tags []string `json:"tags"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{query: "rows=1,2,3&rows=4,5,6", want: Params{Rows: [][]int{{1, 2, 3}, {4, 5, 6}}}},
+		{query: "ids=1,2&ids=3&tags=a,b", want: Params{IDs: []int{1, 2, 3}, Tags: []string{"a,b"}}},
+		{query: "rows=1,%202", want: Params{Rows: [][]int{{1, 2}}}},
+		{query: "rows=1,x", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackExplode(t *testing.T) {
+	type Params struct {
+		IDs      []int     `json:"ids,explode=false"`
+		Exploded []int     `json:"exploded,explode=true"`
+		Tags     []string  `json:"tags,explode=false,delim=;"`
+		Points   [][]int   `json:"points,explode=false,delim=|"`
+		Ratios   []float64 `json:"ratios,csv,delim=;"`
+	}
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{query: "ids=1,2,3", want: Params{IDs: []int{1, 2, 3}}},
+		{query: "ids=1,2&ids=3", want: Params{IDs: []int{1, 2, 3}}},
+		{query: "exploded=1&exploded=2", want: Params{Exploded: []int{1, 2}}},
+		{query: "tags=a,b%3Bc", want: Params{Tags: []string{"a,b", "c"}}},
+		{query: "points=1|2&points=3|4", want: Params{Points: [][]int{{1, 2}, {3, 4}}}},
+		{query: "ratios=0.5%3B1.5", want: Params{Ratios: []float64{0.5, 1.5}}},
+		{query: "ids=1,x", wantErr: true},
+		{query: "exploded=1,2", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackPathSegment(t *testing.T) {
+	type Params struct {
+		ID       string `path:"last,noext"`
+		Last     string `path:"last"`
+		Resource string `path:"0"`
+		Year     int    `path:"1,noext"`
+	}
+	testCases := []struct {
+		path    string
+		want    Params
+		wantErr bool
+	}{
+		{path: "/reports/2023.json", want: Params{ID: "2023", Last: "2023.json", Resource: "reports", Year: 2023}},
+		{path: "/reports/2023", want: Params{ID: "2023", Last: "2023", Resource: "reports", Year: 2023}},
+		{path: "/reports/2023.tar.gz", wantErr: true}, // the year 2023.tar
+		{path: "/reports", want: Params{ID: "reports", Last: "reports", Resource: "reports"}},
+		{path: "/", want: Params{}},
+	}
+	for _, c := range testCases {
+		t.Run(c.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, c.path, nil)
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.path, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && params != c.want {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.path, params, c.want)
+			}
+		})
+	}
+
+	var invalid struct {
+		ID string `path:"first"`
+	}
+	if err := form.UnpackWithOption(httptest.NewRequest(http.MethodGet, "/", nil), &invalid, form.Query); err == nil {
+		t.Errorf("Unpack invalid path segment err = nil, want error")
+	}
+}
+
+func TestUnpackNFC(t *testing.T) {
+	type Params struct {
+		Username string   `json:"username,nfc"`
+		Raw      string   `json:"raw"`
+		Tags     []string `json:"tags,nfc"`
+	}
+	const decomposed, composed = "Jose\u0301", "Jos\u00e9"
+	query := url.Values{"username": {decomposed}, "raw": {decomposed}, "tags": {decomposed}}
+	testCases := []struct {
+		desc string
+		d    form.Decoder
+		want Params
+	}{
+		{desc: "tag option", want: Params{Username: composed, Raw: decomposed, Tags: []string{composed}}},
+		{desc: "decoder", d: form.Decoder{NormalizeNFC: true}, want: Params{Username: composed, Raw: composed, Tags: []string{composed}}},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := c.d.Unpack(req, &params, form.Query); err != nil {
+				t.Errorf("Unpack(%s) err: %+v", query.Encode(), err)
+				return
+			}
+			if !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+q, want %+q", query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackMixedMergeOrder(t *testing.T) {
+	body := url.Values{"ids": {"3", "4"}, "sort": {"body"}}
+	req, err := http.NewRequest(http.MethodPost, "http://google.com?ids=1&ids=2&sort=query", strings.NewReader(body.Encode()))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var params struct {
+		IDs  []int  `json:"ids"`
+		Sort string `json:"sort"`
+	}
+	if err := form.UnpackWithOption(req, &params, form.Mixed); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(params.IDs, want) {
+		t.Errorf("Unpack ids got %v, want %v", params.IDs, want)
+	}
+	if want := "query"; params.Sort != want {
+		t.Errorf("Unpack sort got %s, want %s", params.Sort, want)
+	}
+}
+
+func TestFieldError(t *testing.T) {
+	type Params struct {
+		Age      int   `json:"age"`
+		IDs      []int `json:"ids"`
+		Password int   `json:"password,secret"`
+	}
+	testCases := []struct {
+		query   string
+		want    form.FieldError
+		wantMsg string
+	}{
+		{query: "age=x", want: form.FieldError{Field: "age", Value: "x"}, wantMsg: `age: strconv.ParseInt: parsing "x": invalid syntax`},
+		{query: "ids=1&ids=y", want: form.FieldError{Field: "ids", Value: "y"}, wantMsg: `ids: strconv.ParseInt: parsing "y": invalid syntax`},
+		{query: "ids[2]=z", want: form.FieldError{Field: "ids[2]", Value: "z"}, wantMsg: `ids[2]: strconv.ParseInt: parsing "z": invalid syntax`},
+		{query: "password=hunter2", want: form.FieldError{Field: "password", Value: "[REDACTED]"}, wantMsg: `password: invalid value [REDACTED] (*strconv.NumError)`},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			err = form.UnpackWithOption(req, &params, form.Query)
+			var fe *form.FieldError
+			if !errors.As(err, &fe) {
+				t.Errorf("Unpack(%s) err = %v, want a FieldError", c.query, err)
+				return
+			}
+			if fe.Field != c.want.Field || fe.Value != c.want.Value {
+				t.Errorf("Unpack(%s) got field %q value %q, want field %q value %q", c.query, fe.Field, fe.Value, c.want.Field, c.want.Value)
+			}
+			if err.Error() != c.wantMsg {
+				t.Errorf("Unpack(%s) err = %s, want %s", c.query, err, c.wantMsg)
+			}
+			if fe.Unwrap() != fe.Err {
+				t.Errorf("Unpack(%s) Unwrap() = %v, want %v", c.query, fe.Unwrap(), fe.Err)
+			}
+		})
+	}
+
+	t.Run("errors.Is", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "http://google.com?age=x", nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		var params Params
+		if err := form.UnpackWithOption(req, &params, form.Query); !errors.Is(err, strconv.ErrSyntax) {
+			t.Errorf("Unpack err = %v, want wrapping %v", err, strconv.ErrSyntax)
+		}
+	})
+}
+
+func TestUnpackSkipTag(t *testing.T) {
+	type Params struct {
+		Name    string `json:"name"`
+		IsAdmin bool   `json:"-"`
+		Owner   struct {
+			ID int `json:"id"`
+		} `json:"-"`
+		User string `json:"-" header:"X-User"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?name=x&isAdmin=true&-=true&owner.id=1", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("X-User", "alice")
+	params := Params{IsAdmin: true}
+	if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	want := Params{Name: "x", IsAdmin: true, User: "alice"}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("Unpack got %+v, want %+v", params, want)
+	}
+}
+
+func TestDecoderCaseInsensitive(t *testing.T) {
+	type Params struct {
+		UserName string   `json:"userName"`
+		Tags     []string `json:"tags"`
+		Age      int      `json:"age,required"`
+	}
+	testCases := []struct {
+		query string
+		want  Params
+	}{
+		{query: "username=a&TAGS=x&Age=1", want: Params{UserName: "a", Tags: []string{"x"}, Age: 1}},
+		{query: "USERNAME=a&Tags[1]=y&AGE=1", want: Params{UserName: "a", Tags: []string{"", "y"}, Age: 1}},
+		{query: "TAGS=x&Tags=y&tags=z&age=1", want: Params{Tags: []string{"x", "y", "z"}, Age: 1}},
+	}
+	d := form.Decoder{CaseInsensitive: true}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := d.Unpack(req, &params, form.Query); err != nil {
+				t.Errorf("Unpack(%s) err: %+v", c.query, err)
+				return
+			}
+			if !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+
+	t.Run("collision", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "http://google.com?name=a", nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		var params struct {
+			Name     string `json:"name"`
+			NameCaps string `json:"NAME"`
+		}
+		if err := d.Unpack(req, &params, form.Query); err == nil {
+			t.Errorf("Unpack got %+v, want collision error", params)
+		}
+	})
+}
+
+func TestUnpackValues(t *testing.T) {
+	type Params struct {
+		Q    string   `form:"q"`
+		Page int      `form:"page,default=1"`
+		IDs  []int    `form:"ids"`
+		Tags []string `form:"tags,required"`
+	}
+	testCases := []struct {
+		values  url.Values
+		want    Params
+		wantErr bool
+	}{
+		{values: url.Values{"q": {"go"}, "ids": {"1", "2"}, "tags": {"a"}}, want: Params{Q: "go", Page: 1, IDs: []int{1, 2}, Tags: []string{"a"}}},
+		{values: url.Values{"q": {"go"}, "page": {"3"}, "tags[1]": {"b"}}, want: Params{Q: "go", Page: 3, Tags: []string{"", "b"}}},
+		{values: url.Values{"q": {"go"}}, wantErr: true},
+		{values: url.Values{"ids": {"x"}, "tags": {"a"}}, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.values.Encode(), func(t *testing.T) {
+			var params Params
+			if err := form.UnpackValues(c.values, &params, "form"); (err != nil) != c.wantErr {
+				t.Errorf("UnpackValues(%s) err = %v, want error %t", c.values.Encode(), err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("UnpackValues(%s) got %+v, want %+v", c.values.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackFieldsCacheConcurrent(t *testing.T) {
+	type Address struct {
+		City string `json:"city"`
+	}
+	type Params struct {
+		Name    string            `json:"name"`
+		IDs     []int             `json:"ids"`
+		Address Address           `json:"address"`
+		Filter  map[string]string `json:"filter_*"`
+		Agent   string            `header:"User-Agent"`
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := fmt.Sprintf("name=n%d&ids=%d&address.city=c%d&filter_k=v%d", i, i, i, i)
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("User-Agent", fmt.Sprintf("a%d", i))
+			d := form.Decoder{PreserveNonZero: i%2 == 0, CaseInsensitive: i%3 == 0}
+			var params Params
+			if err := d.Unpack(req, &params, form.Query); err != nil {
+				t.Errorf("Unpack(%s) err: %+v", query, err)
+				return
+			}
+			want := Params{
+				Name:    fmt.Sprintf("n%d", i),
+				IDs:     []int{i},
+				Address: Address{City: fmt.Sprintf("c%d", i)},
+				Filter:  map[string]string{"k": fmt.Sprintf("v%d", i)},
+				Agent:   fmt.Sprintf("a%d", i),
+			}
+			if !reflect.DeepEqual(params, want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", query, params, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkUnpack(b *testing.B) {
+	type Params struct {
+		Name    string    `json:"name"`
+		Age     int       `json:"age"`
+		IDs     []int     `json:"ids,max=10"`
+		Active  bool      `json:"active"`
+		Created time.Time `json:"created" time_location:"Asia/Shanghai"`
+		Address struct {
+			City string `json:"city"`
+			Zip  string `json:"zip"`
+		} `json:"address"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?name=x&age=18&ids=1&ids=2&active=true&address.city=y", nil)
+	if err != nil {
+		b.Fatalf("new request: %+v", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var params Params
+		if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+			b.Fatalf("Unpack err: %+v", err)
+		}
+	}
+}
+
+func TestUnpackBytes(t *testing.T) {
+	type Params struct {
+		Data   []byte          `json:"data"`
+		Raw    []byte          `json:"raw,encoding=raw"`
+		Hex    []byte          `json:"hex,encoding=hex"`
+		Blobs  [][]byte        `json:"blobs"`
+		JSON   json.RawMessage `json:"json,encoding=raw"`
+		Digest *[]byte         `json:"digest"`
+	}
+	digest := []byte{0xca, 0xfe}
+	testCases := []struct {
+		query   url.Values
+		want    Params
+		wantErr bool
+	}{
+		{query: url.Values{"data": {"SGVsbG8="}}, want: Params{Data: []byte("Hello")}},
+		{query: url.Values{"raw": {"SGVsbG8="}}, want: Params{Raw: []byte("SGVsbG8=")}},
+		{query: url.Values{"hex": {"cafe"}}, want: Params{Hex: []byte{0xca, 0xfe}}},
+		{query: url.Values{"blobs": {"SGk=", "R28="}}, want: Params{Blobs: [][]byte{[]byte("Hi"), []byte("Go")}}},
+		{query: url.Values{"json": {`{"a":1}`}}, want: Params{JSON: json.RawMessage(`{"a":1}`)}},
+		{query: url.Values{"digest": {"yv4="}}, want: Params{Digest: &digest}},
+		{query: url.Values{"data": {"SGVsbG8"}}, wantErr: true},
+		{query: url.Values{"data": {"!@#$"}}, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query.Encode(), func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query.Encode(), nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query.Encode(), err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query.Encode(), params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackJSONNumber(t *testing.T) {
+	type Params struct {
+		ID      json.Number   `json:"id"`
+		Amounts []json.Number `json:"amounts"`
+	}
+	const id = "12345678901234567890" // loses precision as float64
+	testCases := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{query: "id=" + id, want: Params{ID: id}},
+		{query: "amounts=0.1&amounts=-2.50&amounts=1e-7", want: Params{Amounts: []json.Number{"0.1", "-2.50", "1e-7"}}},
+		{query: "id=12a", wantErr: true},
+		{query: "id=", wantErr: true},
+		{query: "amounts=1&amounts=NaN", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack(%s) got %+v, want %+v", c.query, params, c.want)
+			}
+		})
+	}
+
+	t.Run("precision", func(t *testing.T) {
+		if f, _ := strconv.ParseFloat(id, 64); strconv.FormatFloat(f, 'f', -1, 64) == id {
+			t.Errorf("%s is exact as float64, want a precision loss", id)
+		}
+		req, err := http.NewRequest(http.MethodGet, "http://google.com?id="+id, nil)
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		var params Params
+		if err := form.UnpackWithOption(req, &params, form.Query); err != nil {
+			t.Errorf("Unpack err: %+v", err)
+			return
+		}
+		if params.ID.String() != id {
+			t.Errorf("Unpack got %s, want %s", params.ID, id)
+		}
+		if _, err := params.ID.Int64(); err == nil {
+			t.Errorf("Int64() of %s err = nil, want out of range", params.ID)
+		}
+	})
+}
+
+func TestUnpackBoolTokens(t *testing.T) {
+	testCases := []struct {
+		query   string
+		want    bool
+		wantErr bool
+	}{
+		{query: "b=on", want: true},
+		{query: "b=ON", want: true},
+		{query: "b=yes", want: true},
+		{query: "b=Yes", want: true},
+		{query: "b=off", want: false},
+		{query: "b=Off", want: false},
+		{query: "b=no", want: false},
+		{query: "b=NO", want: false},
+		{query: "b=true", want: true},
+		{query: "b=F", want: false},
+		{query: "b=1", want: true},
+		{query: "b=", want: true},
+		{query: "b", want: true},
+		{query: "b=maybe", wantErr: true},
+		{query: "b=y", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			params := struct {
+				B bool `json:"b"`
+			}{B: !c.want}
+			if err := form.UnpackWithOption(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && params.B != c.want {
+				t.Errorf("Unpack(%s) got %t, want %t", c.query, params.B, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackWithReport(t *testing.T) {
+	type Params struct {
+		Name    string `json:"name"`
+		Age     int    `json:"age"`
+		Page    int    `json:"page,default=1"`
+		Tags    []int  `json:"tags"`
+		Address struct {
+			City string `json:"city"`
+			Zip  string `json:"zip"`
+		} `json:"address"`
+		Agent   string            `header:"User-Agent"`
+		Meta    map[string]string `json:"meta"`
+		Labels  map[string]string `json:"labels"`
+		Filters map[string]string `json:"filter_*"`
+	}
+	req, err := http.NewRequest(http.MethodPatch, "http://google.com", strings.NewReader("name=&tags[1]=2&address.city=x&meta[a]=b&labels.c=d&filter_e=f"))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "go")
+	params := Params{Name: "preset", Age: 18}
+	report, err := form.UnpackWithReport(req, &params, form.Body)
+	if err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	want := map[string]bool{"name": true, "tags": true, "address.city": true, "meta": true, "labels": true, "filter_*": true}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("Unpack report %v, want %v", report, want)
+	}
+	if params.Name != "" || params.Age != 18 || params.Page != 1 {
+		t.Errorf("Unpack got %+v, want the name cleared, age kept and page defaulted", params)
+	}
+}
+
+type pageParams struct {
+	Page int `json:"page"`
+	Size int `json:"size"`
+}
+
+func (p *pageParams) Validate() error {
+	if p.Size < 1 || p.Size > 100 {
+		return fmt.Errorf("size %d out of range [1, 100]", p.Size)
+	}
+	return nil
+}
+
+func TestUnpackValidator(t *testing.T) {
+	testCases := []struct {
+		query   string
+		d       form.Decoder
+		wantErr bool
+	}{
+		{query: "page=1&size=20"},
+		{query: "page=1&size=200", wantErr: true},
+		{query: "page=1", wantErr: true},
+		{query: "page=1&size=200", d: form.Decoder{SkipValidate: true}},
+	}
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("%s skip %t", c.query, c.d.SkipValidate), func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params pageParams
+			if err := c.d.Unpack(req, &params, form.Query); (err != nil) != c.wantErr {
+				t.Errorf("Unpack(%s) err = %v, want error %t", c.query, err, c.wantErr)
+			}
+			values, _ := url.ParseQuery(c.query)
+			if err := c.d.UnpackValues(values, &params); (err != nil) != c.wantErr {
+				t.Errorf("UnpackValues(%s) err = %v, want error %t", c.query, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnpackMultipartTextFields(t *testing.T) {
+	type Params struct {
+		Name   string                  `json:"name"`
+		Age    int                     `json:"age"`
+		Tags   []string                `json:"tags"`
+		Note   string                  `json:"note"`
+		Avatar *multipart.FileHeader   `json:"avatar"`
+		Docs   []*multipart.FileHeader `json:"docs"`
+	}
+	newRequest := func(query string) (*http.Request, error) {
+		var body strings.Builder
+		w := multipart.NewWriter(&body)
+		w.WriteField("name", "alice")
+		w.WriteField("tags", "a")
+		fw, _ := w.CreateFormFile("avatar", "avatar.png")
+		fw.Write([]byte("png"))
+		w.WriteField("age", "18")
+		fw, _ = w.CreateFormFile("docs", "a.txt")
+		fw.Write([]byte("a"))
+		w.WriteField("tags", "b")
+		fw, _ = w.CreateFormFile("docs", "b.txt")
+		fw.Write([]byte("b"))
+		w.WriteField("note", "hi")
+		w.Close()
+		req, err := http.NewRequest(http.MethodPost, "http://google.com?"+query, strings.NewReader(body.String()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req, nil
+	}
+	testCases := []struct {
+		desc      string
+		query     string
+		option    form.Option
+		preParsed bool
+		want      Params
+	}{
+		{desc: "multipart", option: form.Multipart, want: Params{Name: "alice", Age: 18, Tags: []string{"a", "b"}, Note: "hi"}},
+		{desc: "query ignored", query: "name=bob", option: form.Multipart, want: Params{Name: "alice", Age: 18, Tags: []string{"a", "b"}, Note: "hi"}},
+		{desc: "mixed", query: "name=bob&tags=q", option: form.MixedMultipart, want: Params{Name: "bob", Age: 18, Tags: []string{"q", "a", "b"}, Note: "hi"}},
+		{desc: "pre-parsed", option: form.Multipart, preParsed: true, want: Params{Name: "alice", Age: 18, Tags: []string{"a", "b"}, Note: "hi"}},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := newRequest(c.query)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			if c.preParsed {
+				// Parsed by others without populating r.PostForm, e.g., a middleware.
+				mr, err := req.MultipartReader()
+				if err != nil {
+					t.Errorf("multipart reader: %+v", err)
+					return
+				}
+				if req.MultipartForm, err = mr.ReadForm(form.MultipartMaxMemory); err != nil {
+					t.Errorf("read form: %+v", err)
+					return
+				}
+			}
+			var params Params
+			if err := form.UnpackWithOption(req, &params, c.option); err != nil {
+				t.Errorf("Unpack err: %+v", err)
+				return
+			}
+			if params.Avatar == nil || params.Avatar.Filename != "avatar.png" {
+				t.Errorf("Unpack avatar got %+v, want avatar.png", params.Avatar)
+			}
+			if len(params.Docs) != 2 || params.Docs[0].Filename != "a.txt" || params.Docs[1].Filename != "b.txt" {
+				t.Errorf("Unpack docs got %+v, want a.txt and b.txt", params.Docs)
+			}
+			params.Avatar, params.Docs = nil, nil
+			if !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unpack got %+v, want %+v", params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnpackMultipartFile(t *testing.T) {
+	var body strings.Builder
+	w := multipart.NewWriter(&body)
+	for _, file := range []struct{ name, filename, content string }{
+		{"file", "a.txt", "hello"},
+		{"reader", "b.txt", "world"},
+		{"files", "c.txt", "foo"},
+		{"files", "d.txt", "bar"},
+	} {
+		fw, _ := w.CreateFormFile(file.name, file.filename)
+		fw.Write([]byte(file.content))
+	}
+	w.Close()
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body.String()))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var params struct {
+		File   multipart.File   `json:"file"`
+		Reader io.ReadCloser    `json:"reader"`
+		Files  []multipart.File `json:"files"`
+	}
+	if err := form.UnpackWithOption(req, &params, form.Multipart); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	readers := []io.ReadCloser{params.File, params.Reader}
+	for _, f := range params.Files {
+		readers = append(readers, f)
+	}
+	var got []string
+	for _, r := range readers {
+		if r == nil {
+			t.Errorf("Unpack got a nil reader")
+			return
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("read file: %+v", err)
+		}
+		r.Close()
+		got = append(got, string(b))
+	}
+	if want := []string{"hello", "world", "foo", "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unpack files read %q, want %q", got, want)
+	}
+}
+
+func TestUploadSizeLimits(t *testing.T) {
+	newRequest := func(sizes ...int) *http.Request {
+		var body strings.Builder
+		w := multipart.NewWriter(&body)
+		for i, size := range sizes {
+			fw, _ := w.CreateFormFile("files", fmt.Sprintf("%d.txt", i))
+			fw.Write([]byte(strings.Repeat("x", size)))
+		}
+		w.Close()
+		req, _ := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req
+	}
+	defer func(maxFile, maxTotal int64) {
+		form.MaxFileSize, form.MaxTotalUploadSize = maxFile, maxTotal
+	}(form.MaxFileSize, form.MaxTotalUploadSize)
+	cases := []struct {
+		name     string
+		maxFile  int64
+		maxTotal int64
+		sizes    []int
+		wantErr  string
+	}{
+		{"unlimited", 0, 0, []int{100, 200}, ""},
+		{"within", 100, 300, []int{100, 100, 100}, ""},
+		{"oversized file", 100, 0, []int{10, 101}, `files: file "1.txt" of 101 bytes exceeds the max file size 100`},
+		{"total exceeded", 100, 250, []int{50, 50, 50, 50, 50, 50}, `files: file "5.txt" of 50 bytes exceeds the max total upload size 250, 300 bytes in total`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			form.MaxFileSize, form.MaxTotalUploadSize = c.maxFile, c.maxTotal
+			var params struct {
+				Files []*multipart.FileHeader `json:"files"`
+			}
+			err := form.UnpackWithOption(newRequest(c.sizes...), &params, form.Multipart)
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Errorf("Unpack err = %v, want %s", err, c.wantErr)
+				}
+				var fe *form.FieldError
+				if !errors.As(err, &fe) || fe.Field != "files" {
+					t.Errorf("Unpack err = %#v, want a FieldError of files", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack err: %+v", err)
+			}
+			if len(params.Files) != len(c.sizes) {
+				t.Errorf("Unpack got %d files, want %d", len(params.Files), len(c.sizes))
+			}
+		})
+	}
+}
+
+func TestUnpackAccept(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	newRequest := func(contentType, content string) *http.Request {
+		var body strings.Builder
+		w := multipart.NewWriter(&body)
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="avatar"; filename="avatar.png"`)
+		h.Set("Content-Type", contentType)
+		pw, _ := w.CreatePart(h)
+		pw.Write([]byte(content))
+		w.Close()
+		req, _ := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req
+	}
+	type plain struct {
+		Avatar *multipart.FileHeader `json:"avatar,accept=image/png;image/jpeg"`
+	}
+	type sniffed struct {
+		Avatar *multipart.FileHeader `json:"avatar,accept=image/*,sniff"`
+	}
+	cases := []struct {
+		name        string
+		ptr         interface{}
+		contentType string
+		content     string
+		wantErr     string
+	}{
+		{"accepted", &plain{}, "image/png", png, ""},
+		{"accepted case-insensitively", &plain{}, "Image/JPEG", "jpeg", ""},
+		{"rejected", &plain{}, "application/pdf", "%PDF-1.4", `avatar: file "avatar.png" of content type "application/pdf" is not accepted`},
+		{"wildcard sniffed", &sniffed{}, "image/png", png, ""},
+		{"spoofed", &sniffed{}, "image/png", "<html><script>alert(1)</script></html>", `avatar: file "avatar.png" of content type "image/png" is detected as "text/html" which is not accepted`},
+		{"spoofed unchecked", &plain{}, "image/png", "<html><script>alert(1)</script></html>", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := form.UnpackWithOption(newRequest(c.contentType, c.content), c.ptr, form.Multipart)
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Errorf("Unpack err = %v, want %s", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack err: %+v", err)
+			}
+		})
+	}
+}
+
+func TestDecoderConcurrentTags(t *testing.T) {
+	type params struct {
+		Name string `json:"name" http:"username"`
+		Age  int    `json:"age" http:"user_age"`
+	}
+	decoders := []struct {
+		d    *form.Decoder
+		url  string
+		want params
+	}{
+		{&form.Decoder{Tag: "json"}, "http://google.com?name=a&age=1&username=x", params{Name: "a", Age: 1}},
+		{&form.Decoder{Tag: "http"}, "http://google.com?name=a&username=b&user_age=2", params{Name: "b", Age: 2}},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, c := range decoders {
+			wg.Add(1)
+			go func(d *form.Decoder, url string, want params) {
+				defer wg.Done()
+				req, err := http.NewRequest(http.MethodGet, url, nil)
+				if err != nil {
+					t.Errorf("new request: %+v", err)
+					return
+				}
+				var got params
+				if err := d.Unpack(req, &got, form.Query); err != nil {
+					t.Errorf("Unpack err: %+v", err)
+					return
+				}
+				if got != want {
+					t.Errorf("Unpack with tag %s got %+v, want %+v", d.Tag, got, want)
+				}
+			}(c.d, c.url, c.want)
+		}
+	}
+	wg.Wait()
+}
+
+func TestDecoderCollectErrors(t *testing.T) {
+	var params struct {
+		Age   int    `json:"age"`
+		Bool  bool   `json:"bool"`
+		IDs   []int  `json:"ids"`
+		Name  string `json:"name"`
+		Count uint   `json:"count"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?name=a&age=x&ids=1&ids=y&count=-1&bool=maybe", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	d := form.Decoder{CollectErrors: true}
+	err = d.Unpack(req, &params, form.Query)
+	errs, ok := err.(form.MultiError)
+	if !ok {
+		t.Errorf("Unpack err = %#v, want a MultiError", err)
+		return
+	}
+	var fields []string
+	for _, err := range errs {
+		var fe *form.FieldError
+		if !errors.As(err, &fe) {
+			t.Errorf("Unpack err = %#v, want a FieldError", err)
+			continue
+		}
+		fields = append(fields, fe.Field)
+	}
+	if want := []string{"age", "bool", "count", "ids"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("Unpack errors of fields %q, want %q", fields, want)
+	}
+	if params.Name != "a" {
+		t.Errorf("Unpack name = %q, want a", params.Name)
+	}
+
+	// Stops at the first failure by default.
+	if err := (&form.Decoder{}).Unpack(req, &params, form.Query); err == nil {
+		t.Errorf("Unpack want err")
+	} else if _, ok := err.(form.MultiError); ok {
+		t.Errorf("Unpack err = %v, want a single error", err)
+	}
+}
+
+func TestDecoderCollectErrorsMultipart(t *testing.T) {
+	var body strings.Builder
+	w := multipart.NewWriter(&body)
+	w.WriteField("age", "x")
+	w.WriteField("name", "a")
+	fw, _ := w.CreateFormFile("data", "a.bin")
+	fw.Write([]byte("data"))
+	fw, _ = w.CreateFormFile("file", "b.txt")
+	fw.Write([]byte("file"))
+	w.Close()
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body.String()))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var params struct {
+		Age  int                   `json:"age"`
+		Name string                `json:"name"`
+		Data []byte                `json:"data"`
+		File *multipart.FileHeader `json:"file"`
+	}
+	d := form.Decoder{CollectErrors: true}
+	err = d.Unpack(req, &params, form.Multipart)
+	errs, ok := err.(form.MultiError)
+	if !ok {
+		t.Errorf("Unpack err = %#v, want a MultiError", err)
+		return
+	}
+	var fields []string
+	for _, err := range errs {
+		var fe *form.FieldError
+		if errors.As(err, &fe) {
+			fields = append(fields, fe.Field)
+		}
+	}
+	if want := []string{"age", "data"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("Unpack errors of fields %q, want %q", fields, want)
+	}
+	if params.Name != "a" || params.File == nil || params.File.Filename != "b.txt" {
+		t.Errorf("Unpack got name %q file %+v, want a and b.txt", params.Name, params.File)
+	}
+}
+
+func TestDecoderMaxMemory(t *testing.T) {
+	var body strings.Builder
+	w := multipart.NewWriter(&body)
+	fw, _ := w.CreateFormFile("file", "a.txt")
+	fw.Write([]byte(strings.Repeat("x", 1024)))
+	w.Close()
+	cases := []struct {
+		name       string
+		d          form.Decoder
+		wantOnDisk bool
+	}{
+		{"default", form.Decoder{}, false},
+		{"small", form.Decoder{MaxMemory: 100}, true},
+		{"file size", form.Decoder{MaxFileSize: 100}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body.String()))
+			req.Header.Set("Content-Type", w.FormDataContentType())
+			var params struct {
+				File *multipart.FileHeader `json:"file"`
+			}
+			err := c.d.Unpack(req, &params, form.Multipart)
+			defer req.MultipartForm.RemoveAll()
+			if c.d.MaxFileSize > 0 {
+				if err == nil {
+					t.Errorf("Unpack want err of the max file size")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack err: %+v", err)
+				return
+			}
+			f, err := params.File.Open()
+			if err != nil {
+				t.Errorf("open file: %+v", err)
+				return
+			}
+			defer f.Close()
+			if _, onDisk := f.(*os.File); onDisk != c.wantOnDisk {
+				t.Errorf("Unpack file on disk = %t, want %t", onDisk, c.wantOnDisk)
+			}
+		})
+	}
+}
+
+func TestUnpackGrouped(t *testing.T) {
+	type item struct {
+		Name string   `json:"name"`
+		Qty  int      `json:"qty"`
+		Tags []string `json:"tags"`
+	}
+	type params struct {
+		Items []item `json:"items"`
+	}
+	cases := []struct {
+		name    string
+		query   string
+		want    []item
+		wantErr string
+	}{
+		{"two items", "items[0].name=a&items[0].qty=2&items[1].name=b&items[1].qty=5", []item{{Name: "a", Qty: 2}, {Name: "b", Qty: 5}}, ""},
+		{"out of order", "items[1].qty=5&items[0].name=a&items[1].name=b&items[0].qty=2", []item{{Name: "a", Qty: 2}, {Name: "b", Qty: 5}}, ""},
+		{"sparse", "items[0].name=a&items[2].name=c", []item{{Name: "a"}, {}, {Name: "c"}}, ""},
+		{"nested slice", "items[0].tags[1]=y&items[0].tags[0]=x&items[0].tags[2]=z", []item{{Tags: []string{"x", "y", "z"}}}, ""},
+		{"unknown field", "items[0].name=a&items[0].color=red", []item{{Name: "a"}}, ""},
+		{"malformed index", "items[x].name=a", nil, `items[x].name: invalid index "x"`},
+		{"negative index", "items[-1].name=a", nil, `items[-1].name: invalid index "-1"`},
+		{"index too large", "items[1001].name=a", nil, `items[1001].name: index 1001 exceeds the max 1000`},
+		{"invalid value", "items[0].qty=x", nil, `items[0].qty: strconv.ParseInt: parsing "x": invalid syntax`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://google.com?"+c.query, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var got params
+			err = form.UnpackWithOption(req, &got, form.Query)
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Errorf("Unpack err = %v, want %s", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unpack err: %+v", err)
+				return
+			}
+			if !reflect.DeepEqual(got.Items, c.want) {
+				t.Errorf("Unpack got %+v, want %+v", got.Items, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderPreserveNonZeroValidators(t *testing.T) {
+	type Params struct {
+		Name string `json:"name,required"`
+		Page int    `json:"page,default=1"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?name=eve&page=3", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	validated := make(map[string]interface{})
+	d := form.Decoder{
+		PreserveNonZero: true,
+		Validators: map[string]func(string, reflect.Value) error{
+			"name": func(name string, v reflect.Value) error {
+				validated[name] = v.Interface()
+				if v.String() == "" {
+					return errors.New("empty")
+				}
+				return nil
+			},
+			"page": func(name string, v reflect.Value) error {
+				validated[name] = v.Interface()
+				return nil
+			},
+		},
+	}
+	params := Params{Name: "preset"}
+	if err := d.Unpack(req, &params, form.Query); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	if want := (Params{Name: "preset", Page: 3}); params != want {
+		t.Errorf("Unpack got %+v, want %+v", params, want)
+	}
+	if want := map[string]interface{}{"name": "preset", "page": 3}; !reflect.DeepEqual(validated, want) {
+		t.Errorf("Unpack validated %v, want %v", validated, want)
+	}
+}
+
+func TestUnpackMultipartFileClosed(t *testing.T) {
+	newRequest := func(files ...string) *http.Request {
+		var body strings.Builder
+		w := multipart.NewWriter(&body)
+		for i := 0; i < len(files); i += 2 {
+			fw, _ := w.CreateFormFile(files[i], files[i]+".txt")
+			fw.Write([]byte(files[i+1]))
+		}
+		w.Close()
+		req, _ := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req
+	}
+	// The files are stored on disk, which fail to read once closed.
+	d := form.Decoder{MaxMemory: 1}
+
+	t.Run("last wins", func(t *testing.T) {
+		req := newRequest("doc", "first", "doc", "last")
+		defer func() { req.MultipartForm.RemoveAll() }()
+		var params struct {
+			Doc multipart.File `json:"doc"`
+		}
+		if err := d.Unpack(req, &params, form.Multipart); err != nil {
+			t.Errorf("Unpack err: %+v", err)
+			return
+		}
+		defer params.Doc.Close()
+		if b, _ := ioutil.ReadAll(params.Doc); string(b) != "last" {
+			t.Errorf("Unpack read %q, want last", b)
+		}
+	})
+
+	t.Run("closed on failure", func(t *testing.T) {
+		for i := 0; i < 10; i++ { // of the random order of the fields
+			req := newRequest("doc", "text", "img", "not an image")
+			var params struct {
+				Doc multipart.File `json:"doc"`
+				Img multipart.File `json:"img,accept=image/png"`
+			}
+			if err := d.Unpack(req, &params, form.Multipart); err == nil {
+				t.Errorf("Unpack want err of the content type")
+			}
+			if params.Img != nil {
+				t.Errorf("Unpack opened the rejected file")
+			}
+			if params.Doc != nil {
+				if _, err := params.Doc.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+					t.Errorf("Unpack left the file open, read err = %v", err)
+				}
+			}
+			req.MultipartForm.RemoveAll()
+		}
+	})
+}
+
+func TestDecoderCaseInsensitiveMixed(t *testing.T) {
+	var params struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://google.com?Name=query&TAGS=q", strings.NewReader("name=body&NAME=body2&tags=b"))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	d := form.Decoder{CaseInsensitive: true}
+	if err := d.Unpack(req, &params, form.Mixed); err != nil {
+		t.Errorf("Unpack err: %+v", err)
+		return
+	}
+	if params.Name != "query" {
+		t.Errorf("Unpack name = %q, want the query one", params.Name)
+	}
+	if want := []string{"q", "b"}; !reflect.DeepEqual(params.Tags, want) {
+		t.Errorf("Unpack tags = %q, want %q", params.Tags, want)
+	}
+}
@@ -0,0 +1,80 @@
+package form
+
+import "strings"
+
+// tagOptions is the options following the name in a field tag,
+// e.g., `json:"ids,max=100"` has the option max with value 100.
+type tagOptions map[string]string
+
+// listOptions is the options whose value is a comma separated list,
+// e.g., `json:"version,requiredfor=PUT,PATCH"` or `json:"tags,default=a,b"`.
+var listOptions = map[string]bool{
+	"requiredfor": true,
+	"default":     true,
+}
+
+// flagOptions is the options without value, which never continue the value of a list option.
+var flagOptions = map[string]bool{
+	// Options of this package.
+	"rawquery":     true,
+	"rawbody":      true,
+	"skipempty":    true,
+	"unhtml":       true,
+	"secret":       true,
+	"lines":        true,
+	"strictlen":    true,
+	"replace":      true,
+	"kvlist":       true,
+	"csv":          true,
+	"noext":        true,
+	"nfc":          true,
+	"required":     true,
+	"allowdefault": true,
+	"sniff":        true,
+	// Options of encoding/json, since the tag key defaults to `json`.
+	"omitempty": true,
+	"string":    true,
+}
+
+// parseTag splits a field tag into its name and options.
+func parseTag(tag string) (string, tagOptions) {
+	parts := strings.Split(tag, ",")
+	opts := make(tagOptions, len(parts)-1)
+	var list string // the list option being parsed
+	for _, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			opts[kv[0]] = kv[1]
+			list = ""
+			if listOptions[kv[0]] {
+				list = kv[0]
+			}
+			continue
+		}
+		if list != "" && !flagOptions[part] {
+			opts[list] += "," + part
+			continue
+		}
+		opts[part] = ""
+		list = ""
+	}
+	return parts[0], opts
+}
+
+// Has reports whether the option exists.
+func (o tagOptions) Has(name string) bool {
+	_, ok := o[name]
+	return ok
+}
+
+// List returns the values of the list option.
+func (o tagOptions) List(name string) []string {
+	v, ok := o[name]
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
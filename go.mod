@@ -1,3 +1,9 @@
 module github.com/longkai/encoding
 
 go 1.13
+
+require (
+	golang.org/x/text v0.13.0
+	google.golang.org/protobuf v1.28.1
+	gopkg.in/yaml.v3 v3.0.1
+)
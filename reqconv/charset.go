@@ -0,0 +1,68 @@
+package reqconv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// transcodeBody replaces the body of r in the charset with the one transcoded into UTF-8,
+// e.g., the legacy form posts in GBK or Shift_JIS.
+// The form values are transcoded after percent-decoding, since the clients escape the non-ASCII bytes.
+func transcodeBody(r *http.Request, charset string, form bool) error {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return fmt.Errorf("unsupported charset %s: %v", charset, err)
+	}
+	if name, _ := htmlindex.Name(enc); name == "utf-8" {
+		return nil
+	}
+	// The charset applies to the decompressed body.
+	if err := decompressBody(r); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if form {
+		b, err = transcodeForm(b, enc)
+	} else {
+		b, err = enc.NewDecoder().Bytes(b)
+	}
+	if err != nil {
+		return fmt.Errorf("transcode body from %s: %v", charset, err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	return nil
+}
+
+// transcodeForm transcodes the keys and values of the application/x-www-form-urlencoded body b into UTF-8,
+// it returns the body re-encoded.
+func transcodeForm(b []byte, enc encoding.Encoding) ([]byte, error) {
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, err
+	}
+	dec := enc.NewDecoder()
+	transcoded := make(url.Values, len(values))
+	for key, vs := range values {
+		if key, err = dec.String(key); err != nil {
+			return nil, err
+		}
+		for _, v := range vs {
+			if v, err = dec.String(v); err != nil {
+				return nil, err
+			}
+			transcoded[key] = append(transcoded[key], v)
+		}
+	}
+	return []byte(transcoded.Encode()), nil
+}
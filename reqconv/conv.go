@@ -3,22 +3,65 @@ Package reqconv implements auto parsing a HTTP request as golang struct accordin
 The supported content type are:
 
 	- application/json
+	- application/merge-patch+json, applied onto the given pointer per RFC 7386
 	- application/xml
+	- the media types of the +json or +xml structured syntax suffix, e.g., application/vnd.api+json
 	- multipart/form-data
 	- application/x-www-form-urlencoded
+	- application/x-framed-json, the length-prefixed JSON frames into a slice, see FramedJSON
+	- application/yaml or text/yaml, by the `yaml` tag
 
 For a request without body, e.g., GET, DELETE, HEAD, TRACE, it will parse the URL query into given pointer.
+So does a request of the other methods, e.g., POST, PUT or PATCH, which arrives without body, regardless of its content type,
+while the one with body is parsed by its content type alike.
 
-It returns a error when other types incoming.
+A body of the gzip or deflate Content-Encoding is decompressed before parsing,
+the request body of a form is replaced with the decompressed one as well, the other encodings are rejected.
+The body is limited by MaxBodyBytes if set, ErrBodyTooLarge is returned beyond it.
+The JSON and XML bodies are buffered and restored after parsing so that they can be read again,
+or decoded as they're read by Decoder.Stream for the large payloads instead.
+A body in the charset other than UTF-8, e.g., `application/json; charset=gbk`, is transcoded into UTF-8 before parsing,
+the request body is replaced with the transcoded one as well.
+
+It returns a error when other types incoming, unless registered by Register.
+The format may be selected by the URL instead, e.g., ?format=xml or /users.xml,
+see Decoder.FormatQuery and Decoder.FormatSuffix.
 
 As of Golang struct, the supported types are:
 
-	- int
+	- int, int8, int16, int32, int64
+	- uint, uint8, uint16, uint32, uint64
 	- bool
 	- string
-	- float64
-	- *multipart.FileHeader
-	- slice of above
+	- float32, float64
+	- time.Time, in the layout of the `layout` option or the `time_format` tag if any, e.g., `json:"from,layout=unix"`,
+	  and the zone of the `time_location` tag or UTC
+	- big.Rat, from a fraction or decimal, e.g., 3/4 or 0.75
+	- json.Number, the exact representation of a number, e.g., the large integers
+	- []byte, from base64, or the value as is by the `encoding=raw` option
+	- *multipart.FileHeader, of the media types in the `accept` option if any, e.g., `json:"avatar,accept=image/png;image/jpeg"`,
+	  and the `sniff` option checks the content detected as well
+	- multipart.File or the interface it implements, e.g., io.ReadCloser, opened from the file part, which the caller must Close
+	- encoding.TextUnmarshaler, e.g., net.IP
+	- pointer of above, left nil if absent, e.g., *int
+	- slice of above, also by indexed keys, e.g., item[0]=a&item[1]=b, or appended by item[]=a
+	- struct of above, keyed by dotted path, e.g., filter.name=x&filter.id=3
+	- slice of struct, keyed by index and dotted path, e.g., items[0].name=a&items[1].name=b
+	- map of string keys to above or slice of above, keyed by the bracket or dotted syntax,
+	  e.g., meta[foo]=bar or meta.foo=bar, or by a key prefix, e.g., `json:"filter_*"` for filter_name=x
+	- struct from a JSON value, e.g., a multipart part carries the metadata of a sibling file part
+
+A field may be bound from the request other than the form by the tags:
+
+	- cookie, the request cookie, e.g., `cookie:"prefs,signed"`,
+	  the `signed` option verifies the value against form.Decoder.CookieKey before binding
+	- header, the request header, e.g., `header:"Sec-WebSocket-Protocol"`,
+	  a slice field takes the comma separated list elements
+	- url, the request URL component, i.e., host, hostname, port, scheme or path, e.g., `url:"host"`
+	- path, the URL path segment, i.e., last or the index, e.g., `path:"last,noext"` for 2023 of /reports/2023.json,
+	  the `noext` option strips the extension
+	- meta, the request metadata, i.e., method, remote_addr, proto or tls, e.g., `meta:"remote_addr"`
+	- partheader, the header of a file part of the multipart form, e.g., `partheader:"file,X-Checksum"`
 
 For example, a file upload request:
 
@@ -39,6 +82,10 @@ You can change it by:
 
 If no tag specified, it will use cammel case of the field name since most languages fields start with lower case.
 
+As of JSON, a time.Time field tagged with `time_format` is parsed in the layout as well, e.g.:
+
+	Due time.Time `json:"due" time_format:"02/01/2006"`
+
 As of xml, however, you must use the `xml` tag.
 */
 package reqconv
@@ -46,56 +93,460 @@ package reqconv
 import (
 	"bytes"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/longkai/encoding/form"
 )
 
+// Decoder decodes HTTP requests into Golang struct according to their content types.
+// The zero value is ready to use.
+type Decoder struct {
+	// Form decodes the form data and URL query, nil means the default one.
+	Form *form.Decoder
+	// UseNumber decodes JSON numbers into interface{} fields as json.Number instead of float64,
+	// e.g., for the large integer IDs which lose precision as float64.
+	UseNumber bool
+	// JSONPaths resolves the fields tagged with a dotted path into the JSON body,
+	// e.g., `json:"data.totals.grand"`, so that a deeply nested value can be pulled into a flat field.
+	JSONPaths bool
+	// CoerceArrays smooths over the JSON schema drift of the top-level object members,
+	// i.e., a single value for a slice field is decoded as a one-element slice,
+	// and a one-element array for a scalar field is decoded as its element.
+	CoerceArrays bool
+	// NumbersAsStrings decodes the JSON numbers of the top-level object members into string fields,
+	// including the elements of []string fields, e.g., the IDs sent as JSON numbers by the JavaScript clients.
+	NumbersAsStrings bool
+	// XMLElement is the name of the repeated XML elements to decode into a slice target,
+	// e.g., `item` for the body <items><item>..</item><item>..</item></items>.
+	// It takes effect only if the target is a slice.
+	XMLElement string
+	// OverrideContentTypeHeader is the header consulted before Content-Type for the media type if present,
+	// e.g., X-Original-Content-Type set by the proxies which rewrite the Content-Type.
+	OverrideContentTypeHeader string
+	// Unmarshalers registers the body unmarshalers keyed by media type, they take precedence
+	// over the built-in ones, e.g., protojson for application/json of the proto-backed APIs.
+	Unmarshalers map[string]func(b []byte, ptr interface{}) error
+	// BodyTransform transforms the raw body before it's unmarshaled, e.g., to decrypt or decompress.
+	// It applies to the JSON, merge patch, XML and registered media types, not the form ones.
+	// The request body is restored as is, rather than transformed.
+	BodyTransform func(b []byte) ([]byte, error)
+	// FormatQuery is the URL query parameter selecting the body format instead of the Content-Type,
+	// e.g., format for ?format=xml, which is form, multipart, or the subtype of a registered media type
+	// without the x- prefix, e.g., json, xml or yaml. A multipart body must declare its boundary in the Content-Type.
+	FormatQuery string
+	// FormatSuffix selects the body format by the URL path suffix instead of the Content-Type,
+	// e.g., /users.xml, the FormatQuery takes precedence if both present.
+	FormatSuffix bool
+	// Fallbacks is the media types tried in order when the body fails to decode as the declared one,
+	// e.g., application/x-www-form-urlencoded for the form bodies mislabeled as JSON.
+	// The error of the declared one is returned if all fail.
+	// Note a failed attempt may have populated ptr partially.
+	Fallbacks []string
+	// Stream decodes the JSON and XML bodies as they're read rather than buffering the whole body,
+	// e.g., for the large payloads. The body is consumed rather than restored, so it can't be read again.
+	// It takes no effect if the whole body is required, i.e., for the Content-Encoding, BodyTransform and Fallbacks,
+	// while the JSON is buffered for NumbersAsStrings, CoerceArrays, JSONPaths and the `time_format` fields.
+	Stream bool
+	// SkipValidate skips calling the Validate method of ptr implementing form.Validator after unmarshaling,
+	// e.g., for the callers validating elsewhere.
+	SkipValidate bool
+
+	// onBind is the form.Decoder.OnBind of the form bound by an Unmarshal call, deferred after validating.
+	onBind *func()
+}
+
+var defaultDecoder Decoder
+
+// builtinMediaTypes is the media types supported out of the box.
+var builtinMediaTypes = []string{
+	"application/json",
+	"application/merge-patch+json",
+	"application/xml",
+	"multipart/form-data",
+	"application/x-www-form-urlencoded",
+}
+
+// UnsupportedMediaTypeError is returned when the request content type is not supported.
+// It carries the supported media types, e.g., for a 415 response with the Accept-Post header.
+type UnsupportedMediaTypeError struct {
+	ContentType string
+	supported   []string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("unsupported content type: %s", e.ContentType)
+}
+
+// Supported returns the supported media types.
+func (e *UnsupportedMediaTypeError) Supported() []string {
+	return append([]string(nil), e.supported...)
+}
+
 // Unmarshal auto parses a HTTP request r into ptr according to its content type.
 func Unmarshal(r *http.Request, ptr interface{}) error {
+	return defaultDecoder.Unmarshal(r, ptr)
+}
+
+// Unmarshal auto parses a HTTP request r into ptr according to its content type.
+// Then ptr is validated by its Validate method if it implements form.Validator, unless Decoder.SkipValidate.
+// The form.Decoder.OnBind of the form data or URL query is called after validating, only if it succeeds.
+func (d *Decoder) Unmarshal(r *http.Request, ptr interface{}) error {
+	var onBind func()
+	call := *d
+	call.onBind = &onBind
+	if err := call.unmarshalRequest(r, ptr); err != nil {
+		return err
+	}
+	if v, ok := ptr.(form.Validator); ok && !d.SkipValidate {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	if onBind != nil {
+		onBind()
+	}
+	return nil
+}
+
+func (d *Decoder) unmarshalRequest(r *http.Request, ptr interface{}) error {
 	// If the request has no body, we could only parse the URL query.
 	switch r.Method {
 	// Which method MUST NOT have body? See https://tools.ietf.org/html/rfc7231#section-4.3
 	case http.MethodGet, http.MethodDelete, http.MethodHead, http.MethodTrace:
-		return form.UnpackWithOption(r, ptr, form.Query)
+		return d.formDecoder().Unpack(r, ptr, form.Query)
+	}
+	// Neither does the request of other methods arriving without body, e.g., a PATCH of the query only.
+	if r.Body == nil || r.Body == http.NoBody {
+		r.Body = http.NoBody // so that http.Request.ParseForm won't fail for the missing body
+		return d.formDecoder().Unpack(r, ptr, form.Query)
+	}
+	if MaxBodyBytes <= 0 {
+		return d.unmarshalBody(r, ptr)
+	}
+	body := &maxBodyReader{r: r.Body, n: MaxBodyBytes}
+	r.Body = body
+	err := d.unmarshalBody(r, ptr)
+	if err != nil && body.exceeded {
+		return fmt.Errorf("%w: limit %d bytes", ErrBodyTooLarge, MaxBodyBytes)
+	}
+	return err
+}
+
+// unmarshalBody parses r with body into ptr according to its content type.
+func (d *Decoder) unmarshalBody(r *http.Request, ptr interface{}) error {
+	if mediaType, ok, err := d.formatMediaType(r); ok {
+		if err != nil {
+			return err
+		}
+		if err := d.unmarshalAs(r, ptr, mediaType); err != nil {
+			return fmt.Errorf("parse request body as %s: %w", mediaType, err)
+		}
+		return nil
 	}
 
-	ct := r.Header.Get("Content-Type")
+	var ct string
+	if d.OverrideContentTypeHeader != "" {
+		ct = r.Header.Get(d.OverrideContentTypeHeader)
+	}
+	if ct == "" {
+		ct = r.Header.Get("Content-Type")
+	}
 	if ct == "" {
 		// RFC 7231, section 3.1.1.5 - empty type
 		//   MAY be treated as application/octet-stream
 		ct = "application/octet-stream"
 	}
-	mediaType, _, err := mime.ParseMediaType(ct)
+	mediaType, params, err := mime.ParseMediaType(ct)
 	if err != nil {
 		return fmt.Errorf("parse request media type: %v", err)
 	}
+	// The multipart parts are of their own charsets, if any, e.g., the binary files.
+	if charset := params["charset"]; charset != "" && !strings.HasPrefix(mediaType, "multipart/") {
+		if err := transcodeBody(r, charset, mediaType == "application/x-www-form-urlencoded"); err != nil {
+			return err
+		}
+	}
 
-	switch mediaType {
-	case "application/json":
-		err = unmarshal(r, ptr, json.Unmarshal)
-	case "application/xml":
-		err = unmarshal(r, ptr, xml.Unmarshal)
-	case "multipart/form-data":
-		err = form.UnpackWithOption(r, ptr, form.Multipart)
-	case "application/x-www-form-urlencoded":
-		err = form.UnpackWithOption(r, ptr, form.Body)
-	default:
-		return fmt.Errorf("unsupported content type: %s", ct)
+	decode := d.decoder(mediaType)
+	if decode == nil {
+		return &UnsupportedMediaTypeError{ContentType: ct, supported: d.supportedMediaTypes()}
+	}
+	if err := decode(r, ptr); err != nil {
+		for _, fallback := range d.Fallbacks {
+			if fallback != mediaType && d.unmarshalAs(r, ptr, fallback) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("parse request body as %s: %w", mediaType, err)
 	}
-	// Register other types parser? Unlikely, since almost commom media types are above.
+	return nil
+}
 
-	if err != nil {
-		return fmt.Errorf("parse request body as %s: %v", mediaType, err)
+// decoder returns the body decoder of the media type, or nil if not supported.
+func (d *Decoder) decoder(mediaType string) func(r *http.Request, ptr interface{}) error {
+	if unmarshaler, ok := d.Unmarshalers[mediaType]; ok {
+		return func(r *http.Request, ptr interface{}) error {
+			return d.unmarshal(r, ptr, unmarshaler)
+		}
+	}
+	registryMu.RLock()
+	decode, ok := registry[mediaType]
+	if !ok {
+		// The structured syntax suffix, e.g., application/vnd.api+json, see RFC 6839.
+		switch {
+		case strings.HasSuffix(mediaType, "+json"):
+			decode, ok = registry["application/json"]
+		case strings.HasSuffix(mediaType, "+xml"):
+			decode, ok = registry["application/xml"]
+		}
+	}
+	registryMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return func(r *http.Request, ptr interface{}) error {
+		return decode(d, r, ptr)
+	}
+}
+
+var (
+	registryMu sync.RWMutex
+	// registry is the body decoders keyed by media type, seeded with the built-in ones.
+	registry = map[string]func(d *Decoder, r *http.Request, ptr interface{}) error{
+		"application/json": func(d *Decoder, r *http.Request, ptr interface{}) error {
+			if d.streamable(r) {
+				return d.streamJSON(r, ptr)
+			}
+			return d.unmarshal(r, ptr, d.unmarshalJSON)
+		},
+		"application/merge-patch+json": func(d *Decoder, r *http.Request, ptr interface{}) error {
+			return d.unmarshal(r, ptr, mergePatch)
+		},
+		"application/xml": func(d *Decoder, r *http.Request, ptr interface{}) error {
+			if d.streamable(r) {
+				return d.streamXML(r, ptr)
+			}
+			return d.unmarshal(r, ptr, d.unmarshalXML)
+		},
+		"multipart/form-data": func(d *Decoder, r *http.Request, ptr interface{}) error {
+			if err := decompressBody(r); err != nil {
+				return err
+			}
+			return d.formDecoder().Unpack(r, ptr, form.Multipart)
+		},
+		"application/x-www-form-urlencoded": func(d *Decoder, r *http.Request, ptr interface{}) error {
+			if err := decompressBody(r); err != nil {
+				return err
+			}
+			return d.formDecoder().Unpack(r, ptr, form.Body)
+		},
+	}
+)
+
+// Register registers the body decoder of the media type for all the Decoders,
+// e.g., application/x-protobuf or text/csv, it replaces the built-in one of the same media type if any.
+// The per-Decoder Unmarshalers take precedence over it.
+// It's safe for concurrent use, however, it's usually called in init.
+func Register(mediaType string, fn func(r *http.Request, ptr interface{}) error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[mediaType] = func(_ *Decoder, r *http.Request, ptr interface{}) error {
+		return fn(r, ptr)
+	}
+}
+
+// unmarshalAs decodes the body of r as the media type other than the declared one, e.g., a fallback,
+// the Content-Type is replaced during decoding since the form parsing depends on it,
+// unless it declares the media type already, whose parameters are kept, e.g., the multipart boundary.
+func (d *Decoder) unmarshalAs(r *http.Request, ptr interface{}, mediaType string) error {
+	decode := d.decoder(mediaType)
+	if decode == nil {
+		return &UnsupportedMediaTypeError{ContentType: mediaType, supported: d.supportedMediaTypes()}
+	}
+	if declared, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && declared == mediaType {
+		return decode(r, ptr)
+	}
+	ct, ok := r.Header["Content-Type"]
+	r.Header.Set("Content-Type", mediaType)
+	defer func() {
+		if ok {
+			r.Header["Content-Type"] = ct
+		} else {
+			r.Header.Del("Content-Type")
+		}
+	}()
+	return decode(r, ptr)
+}
+
+// UnmarshalByDiscriminator reads the discriminator query parameter key of r,
+// then unmarshals r into a new target created by the factory registered for its value.
+// It returns the populated target, or an error if no factory is registered for the value.
+func UnmarshalByDiscriminator(r *http.Request, key string, registry map[string]func() interface{}) (interface{}, error) {
+	return defaultDecoder.UnmarshalByDiscriminator(r, key, registry)
+}
+
+// UnmarshalByDiscriminator reads the discriminator query parameter key of r,
+// then unmarshals r into a new target created by the factory registered for its value.
+// It returns the populated target, or an error if no factory is registered for the value.
+func (d *Decoder) UnmarshalByDiscriminator(r *http.Request, key string, registry map[string]func() interface{}) (interface{}, error) {
+	value := r.URL.Query().Get(key)
+	newTarget, ok := registry[value]
+	if !ok {
+		return nil, fmt.Errorf("unknown discriminator %s: %q", key, value)
+	}
+	ptr := newTarget()
+	if err := d.Unmarshal(r, ptr); err != nil {
+		return nil, err
+	}
+	return ptr, nil
+}
+
+// formats is the aliases of the media types of the formats in the URL, e.g., ?format=form,
+// besides the subtypes of the registered media types, see formatOf.
+var formats = map[string]string{
+	"form":      "application/x-www-form-urlencoded",
+	"multipart": "multipart/form-data",
+}
+
+// formatOf returns the media type of the format in the URL, e.g., ?format=xml or /users.yaml,
+// either an alias or the subtype of a registered media type without the x- prefix,
+// the first one in order wins if several share a subtype, e.g., application/yaml over text/yaml.
+func formatOf(format string) (string, bool) {
+	if mediaType, ok := formats[format]; ok {
+		return mediaType, true
+	}
+	registryMu.RLock()
+	mediaTypes := make([]string, 0, len(registry))
+	for mediaType := range registry {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	registryMu.RUnlock()
+	sort.Strings(mediaTypes)
+	for _, mediaType := range mediaTypes {
+		subtype := mediaType[strings.IndexByte(mediaType, '/')+1:]
+		if format != "" && strings.TrimPrefix(subtype, "x-") == format {
+			return mediaType, true
+		}
+	}
+	return "", false
+}
+
+// formatMediaType returns the media type of the format in the URL of r if any,
+// the query parameter takes precedence over the path suffix.
+// An unknown format of the query parameter is an error, while an unknown path suffix is ignored,
+// e.g., /files/report.pdf.
+func (d *Decoder) formatMediaType(r *http.Request) (string, bool, error) {
+	if d.FormatQuery != "" {
+		if format := r.URL.Query().Get(d.FormatQuery); format != "" {
+			mediaType, ok := formatOf(format)
+			if !ok {
+				return "", true, &UnsupportedMediaTypeError{ContentType: format, supported: d.supportedMediaTypes()}
+			}
+			return mediaType, true, nil
+		}
+	}
+	if d.FormatSuffix {
+		if mediaType, ok := formatOf(strings.TrimPrefix(path.Ext(r.URL.Path), ".")); ok {
+			return mediaType, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (d *Decoder) supportedMediaTypes() []string {
+	supported := append([]string(nil), builtinMediaTypes...)
+	registryMu.RLock()
+	var registered []string
+	for mediaType := range registry {
+		if !contains(supported, mediaType) {
+			registered = append(registered, mediaType)
+		}
+	}
+	registryMu.RUnlock()
+	sort.Strings(registered)
+	supported = append(supported, registered...)
+	for mediaType := range d.Unmarshalers {
+		if !contains(supported, mediaType) {
+			supported = append(supported, mediaType)
+		}
+	}
+	return supported
+}
+
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Decoder) formDecoder() *form.Decoder {
+	var fd form.Decoder
+	if d.Form != nil {
+		fd = *d.Form
+	}
+	fd.SkipValidate = true // validated once by Unmarshal, see Decoder.SkipValidate
+	if hook := fd.OnBind; hook != nil && d.onBind != nil {
+		// Deferred after validating by Unmarshal.
+		fd.OnBind = func(r *http.Request, ptr interface{}) {
+			*d.onBind = func() { hook(r, ptr) }
+		}
+	}
+	return &fd
+}
+
+func (d *Decoder) unmarshalJSON(b []byte, ptr interface{}) error {
+	var err error
+	// The arrays are coerced first, so the numbers of the coerced ones are stringified as well.
+	if d.CoerceArrays {
+		if b, err = coerceJSONArrays(b, ptr); err != nil {
+			return err
+		}
+	}
+	if d.NumbersAsStrings {
+		if b, err = stringifyJSONNumbers(b, ptr); err != nil {
+			return err
+		}
+	}
+	if b, err = formatJSONTimes(b, ptr); err != nil {
+		return err
+	}
+	if err := d.decodeJSON(b, ptr); err != nil {
+		return err
+	}
+	if d.JSONPaths {
+		return unmarshalJSONPaths(b, ptr, d.decodeJSON)
+	}
+	return nil
+}
+
+func (d *Decoder) decodeJSON(b []byte, ptr interface{}) error {
+	if !d.UseNumber {
+		return json.Unmarshal(b, ptr)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(ptr); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
 	}
 	return nil
 }
 
-func unmarshal(r *http.Request, ptr interface{}, unmarshaler func(b []byte, ptr interface{}) error) error {
+func (d *Decoder) unmarshal(r *http.Request, ptr interface{}, unmarshaler func(b []byte, ptr interface{}) error) error {
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return err
@@ -103,5 +554,13 @@ func unmarshal(r *http.Request, ptr interface{}, unmarshaler func(b []byte, ptr
 	r.Body.Close()
 	// Reset body since caller may read it for some reasons later.
 	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if b, err = decompress(b, r.Header.Get("Content-Encoding")); err != nil {
+		return err
+	}
+	if d.BodyTransform != nil {
+		if b, err = d.BodyTransform(b); err != nil {
+			return fmt.Errorf("transform body: %v", err)
+		}
+	}
 	return unmarshaler(b, ptr)
 }
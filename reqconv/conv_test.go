@@ -1,14 +1,29 @@
 package reqconv_test
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/longkai/encoding/form"
 	"github.com/longkai/encoding/reqconv"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/typepb"
 )
 
 func TestUnmarshal(t *testing.T) {
@@ -99,14 +114,83 @@ func TestUnmarshal(t *testing.T) {
 			params:      Params{Default: "2333"},
 			want:        Params{Default: "2333"},
 		},
+		{
+			desc:        "PUT json",
+			url:         `http://google.com`,
+			method:      http.MethodPut,
+			contentType: `application/json`,
+			body:        `{"q": "golang", "int": 233, "array": [1, 2]}`,
+			want:        Params{Q: "golang", Int: 233, Array: []int{1, 2}},
+		},
+		{
+			desc:        "PATCH json",
+			url:         `http://google.com`,
+			method:      http.MethodPatch,
+			contentType: `application/json`,
+			body:        `{"q": "golang"}`,
+			params:      Params{Int: 233},
+			want:        Params{Q: "golang", Int: 233},
+		},
+		{
+			desc:        "PUT xml",
+			url:         `http://google.com`,
+			method:      http.MethodPut,
+			contentType: `application/xml`,
+			body:        `<xml><q>golang</q><array>1</array><array>2</array></xml>`,
+			want:        Params{Q: "golang", Array: []int{1, 2}},
+		},
+		{
+			desc:        "PATCH xml",
+			url:         `http://google.com`,
+			method:      http.MethodPatch,
+			contentType: `application/xml`,
+			body:        `<xml><int>233</int></xml>`,
+			want:        Params{Int: 233},
+		},
+		{
+			desc:        "PUT body encode",
+			url:         `http://google.com?q=rust`,
+			method:      http.MethodPut,
+			contentType: `application/x-www-form-urlencoded`,
+			body:        `q=golang&int=233`,
+			want:        Params{Q: "golang", Int: 233},
+		},
+		{
+			desc:        "PATCH body encode",
+			url:         `http://google.com`,
+			method:      http.MethodPatch,
+			contentType: `application/x-www-form-urlencoded`,
+			body:        `bool=true`,
+			want:        Params{Bool: true},
+		},
+		{
+			desc:   "PUT without body",
+			url:    `http://google.com?q=golang&int=233`,
+			method: http.MethodPut,
+			want:   Params{Q: "golang", Int: 233},
+		},
+		{
+			desc:        "PATCH without body",
+			url:         `http://google.com?q=golang`,
+			method:      http.MethodPatch,
+			contentType: `application/json`,
+			params:      Params{Default: "2333"},
+			want:        Params{Q: "golang", Default: "2333"},
+		},
+		{
+			desc:   "POST without body",
+			url:    `http://google.com?q=golang`,
+			method: http.MethodPost,
+			want:   Params{Q: "golang"},
+		},
 		{
 			desc:        "non utf-8 encoding",
 			url:         `http://google.com?q=golang`,
 			method:      http.MethodPost,
 			contentType: `application/json; charset=gbk`,
-			body:        `{"q": "ÄãºÃ, hello"}`, // 你好, hello
+			body:        "{\"q\": \"\xc4\xe3\xba\xc3, hello\"}", // 你好, hello in GBK
 			params:      Params{},
-			want:        Params{Q: "ÄãºÃ, hello"}, // golang assume input encoding is utf-8.
+			want:        Params{Q: "你好, hello"}, // transcoded into utf-8.
 		},
 	}
 	for _, c := range testCases {
@@ -207,3 +291,1501 @@ func TestUnmarshalUnsupportedType(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalNestedQuery(t *testing.T) {
+	type Filter struct {
+		Name string `json:"name"`
+		ID   int    `json:"id"`
+	}
+	type Params struct {
+		Q      string `json:"q"`
+		Filter Filter `json:"filter"`
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://google.com?q=golang&filter.name=x&filter.id=3", nil)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	var params Params
+	if err := reqconv.Unmarshal(req, &params); err != nil {
+		t.Errorf("reqconv.Unmarshal: %+v", err)
+	}
+	want := Params{Q: "golang", Filter: Filter{Name: "x", ID: 3}}
+	if params != want {
+		t.Errorf("got %+v, want %+v", params, want)
+	}
+}
+
+func TestDecoderUseNumber(t *testing.T) {
+	const body = `{"id": 9007199254740993, "extra": {"id": 9007199254740993}}`
+	testCases := []struct {
+		desc      string
+		useNumber bool
+		want      interface{}
+	}{
+		{
+			desc: "float64",
+			want: float64(9007199254740993),
+		},
+		{
+			desc:      "json.Number",
+			useNumber: true,
+			want:      json.Number("9007199254740993"),
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			var params struct {
+				ID    interface{}            `json:"id"`
+				Extra map[string]interface{} `json:"extra"`
+			}
+			d := reqconv.Decoder{UseNumber: c.useNumber}
+			if err := d.Unmarshal(req, &params); err != nil {
+				t.Errorf("Unmarshal: %+v", err)
+				return
+			}
+			if params.ID != c.want || params.Extra["id"] != c.want {
+				t.Errorf("got %#v and %#v, want %#v", params.ID, params.Extra["id"], c.want)
+			}
+		})
+	}
+	// The trailing data is rejected like json.Unmarshal.
+	for _, body := range []string{`{"id": 1}}`, `{"id": 1} {}`, `{"id": 1} x`} {
+		req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		var params struct {
+			ID interface{} `json:"id"`
+		}
+		if err := (&reqconv.Decoder{UseNumber: true}).Unmarshal(req, &params); err == nil {
+			t.Errorf("Unmarshal(%s) want error of the trailing data", body)
+		}
+	}
+}
+
+func TestUnmarshalMergePatch(t *testing.T) {
+	type Address struct {
+		City string `json:"city"`
+		Zip  string `json:"zip"`
+	}
+	type Resource struct {
+		Title   string                 `json:"title"`
+		Author  *string                `json:"author"`
+		Tags    map[string]string      `json:"tags"`
+		Address *Address               `json:"address"`
+		Extra   map[string]interface{} `json:"extra"`
+		Meta    interface{}            `json:"meta"`
+	}
+	author := "longkai"
+	newResource := func() Resource {
+		return Resource{
+			Title:   "Hello",
+			Author:  &author,
+			Tags:    map[string]string{"lang": "go", "level": "easy"},
+			Address: &Address{City: "NYC", Zip: "10001"},
+			Extra:   map[string]interface{}{"a": map[string]interface{}{"x": 1.0, "y": 2.0}},
+			Meta:    "draft",
+		}
+	}
+	testCases := []struct {
+		desc string
+		body string
+		want func() Resource
+	}{
+		{
+			desc: "field add",
+			body: `{"tags": {"topic": "json"}}`,
+			want: func() Resource {
+				r := newResource()
+				r.Tags["topic"] = "json"
+				return r
+			},
+		},
+		{
+			desc: "field update",
+			body: `{"title": "Goodbye", "address": {"city": "SF"}}`,
+			want: func() Resource {
+				r := newResource()
+				r.Title = "Goodbye"
+				r.Address.City = "SF"
+				return r
+			},
+		},
+		{
+			desc: "null delete",
+			body: `{"author": null, "title": null, "tags": {"level": null}, "address": {"zip": null}}`,
+			want: func() Resource {
+				r := newResource()
+				r.Title = ""
+				r.Author = nil
+				delete(r.Tags, "level")
+				r.Address.Zip = ""
+				return r
+			},
+		},
+		{
+			desc: "generic null delete",
+			body: `{"extra": {"a": {"x": null, "z": {"w": null}}}, "meta": {"k": {"v": 1, "w": null}}}`,
+			want: func() Resource {
+				r := newResource()
+				r.Extra["a"] = map[string]interface{}{"y": 2.0, "z": map[string]interface{}{}}
+				r.Meta = map[string]interface{}{"k": map[string]interface{}{"v": 1.0}}
+				return r
+			},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPatch, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			got := newResource()
+			if err := reqconv.Unmarshal(req, &got); err != nil {
+				t.Errorf("Unmarshal(%s): %+v", c.body, err)
+				return
+			}
+			if want := c.want(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", c.body, got, want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalByDiscriminator(t *testing.T) {
+	type UserSearch struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	type OrderSearch struct {
+		Type  string `json:"type"`
+		Total int    `json:"total"`
+	}
+	registry := map[string]func() interface{}{
+		"user":  func() interface{} { return new(UserSearch) },
+		"order": func() interface{} { return new(OrderSearch) },
+	}
+	testCases := []struct {
+		desc    string
+		url     string
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			desc: "user",
+			url:  "http://google.com/search?type=user&name=longkai&total=1",
+			want: &UserSearch{Type: "user", Name: "longkai"},
+		},
+		{
+			desc: "order",
+			url:  "http://google.com/search?type=order&name=longkai&total=1",
+			want: &OrderSearch{Type: "order", Total: 1},
+		},
+		{
+			desc:    "unknown",
+			url:     "http://google.com/search?type=product",
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, c.url, nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			got, err := reqconv.UnmarshalByDiscriminator(req, "type", registry)
+			if (err != nil) != c.wantErr {
+				t.Errorf("UnmarshalByDiscriminator(%s) err = %v, want err %t", c.url, err, c.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("UnmarshalByDiscriminator(%s) = %+v, want %+v", c.url, got, c.want)
+			}
+		})
+	}
+}
+
+func TestMultipartJSONSidecar(t *testing.T) {
+	type Meta struct {
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+	}
+	var params struct {
+		Meta Meta                  `json:"metadata"`
+		File *multipart.FileHeader `json:"file"`
+	}
+	body := `------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="metadata"
+Content-Type: application/json
+
+{"title": "hello", "tags": ["a", "b"]}
+------WebKitFormBoundarykhWusB7Rx4ybHQtA
+Content-Disposition: form-data; name="file"; filename="hello.txt"
+Content-Type: text/plain
+
+hello, world
+
+------WebKitFormBoundarykhWusB7Rx4ybHQtA--`
+	r, err := http.NewRequest(http.MethodPost, "https://google.com", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=----WebKitFormBoundarykhWusB7Rx4ybHQtA")
+	if err := reqconv.Unmarshal(r, &params); err != nil {
+		t.Errorf("reqconv.Unmarshal: %+v", err)
+		return
+	}
+	if want := (Meta{Title: "hello", Tags: []string{"a", "b"}}); !reflect.DeepEqual(params.Meta, want) {
+		t.Errorf("metadata got %+v, want %+v", params.Meta, want)
+	}
+	if params.File == nil || params.File.Filename != "hello.txt" {
+		t.Errorf("file got %+v, want hello.txt", params.File)
+	}
+}
+
+func TestDecoderJSONPaths(t *testing.T) {
+	const body = `{"id": "1", "data": {"totals": {"grand": 233, "tax": 3.5}, "items": [1, 2]}}`
+	var params struct {
+		ID    string  `json:"id"`
+		Grand int     `json:"data.totals.grand"`
+		Tax   float64 `json:"data.totals.tax"`
+		Items []int   `json:"data.items"`
+		None  string  `json:"data.none"`
+	}
+	params.None = "default"
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	d := reqconv.Decoder{JSONPaths: true}
+	if err := d.Unmarshal(req, &params); err != nil {
+		t.Errorf("Unmarshal: %+v", err)
+		return
+	}
+	if params.ID != "1" || params.Grand != 233 || params.Tax != 3.5 || !reflect.DeepEqual(params.Items, []int{1, 2}) || params.None != "default" {
+		t.Errorf("got %+v", params)
+	}
+}
+
+func TestUnsupportedMediaTypeError(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://google.com/", strings.NewReader(`...`))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/javascript")
+	var params struct{}
+	err = reqconv.Unmarshal(req, &params)
+	var unsupported *reqconv.UnsupportedMediaTypeError
+	if !errors.As(err, &unsupported) {
+		t.Errorf("Unmarshal err = %v, want UnsupportedMediaTypeError", err)
+		return
+	}
+	if want := "unsupported content type: application/javascript"; err.Error() != want {
+		t.Errorf("error message got %q, want %q", err, want)
+	}
+	supported := strings.Join(unsupported.Supported(), ", ")
+	for _, mediaType := range []string{"application/json", "application/xml", "multipart/form-data", "application/x-www-form-urlencoded"} {
+		if !strings.Contains(supported, mediaType) {
+			t.Errorf("supported media types %s missing %s", supported, mediaType)
+		}
+	}
+}
+
+func TestUnmarshalIndexedForm(t *testing.T) {
+	var params struct {
+		Item []string `json:"item"`
+		IDs  []int    `json:"ids"`
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader("item[1]=b&item[0]=a&ids[0]=1&ids[1]=2"))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := reqconv.Unmarshal(req, &params); err != nil {
+		t.Errorf("Unmarshal: %+v", err)
+		return
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(params.Item, want) {
+		t.Errorf("item got %q, want %q", params.Item, want)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(params.IDs, want) {
+		t.Errorf("ids got %v, want %v", params.IDs, want)
+	}
+}
+
+func TestDecoderUnmarshalers(t *testing.T) {
+	// Enums as strings, which encoding/json fails to decode.
+	const body = `{"kind": "TYPE_STRING", "cardinality": "CARDINALITY_REPEATED", "name": "tags", "jsonName": "tags"}`
+	d := reqconv.Decoder{
+		Unmarshalers: map[string]func(b []byte, ptr interface{}) error{
+			"application/json": func(b []byte, ptr interface{}) error {
+				m, ok := ptr.(proto.Message)
+				if !ok {
+					return json.Unmarshal(b, ptr)
+				}
+				return protojson.Unmarshal(b, m)
+			},
+		},
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var field typepb.Field
+	if err := d.Unmarshal(req, &field); err != nil {
+		t.Errorf("Unmarshal: %+v", err)
+		return
+	}
+	if field.Kind != typepb.Field_TYPE_STRING || field.Cardinality != typepb.Field_CARDINALITY_REPEATED || field.Name != "tags" || field.JsonName != "tags" {
+		t.Errorf("got %v", &field)
+	}
+}
+
+func TestDecoderXMLElement(t *testing.T) {
+	type Item struct {
+		Name string `xml:"name"`
+		Qty  int    `xml:"qty"`
+	}
+	const body = `<items>
+	<item><name>a</name><qty>2</qty></item>
+	<item><name>b</name><qty>5</qty></item>
+	<item><name>c</name><qty>7</qty></item>
+</items>`
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	var items []Item
+	d := reqconv.Decoder{XMLElement: "item"}
+	if err := d.Unmarshal(req, &items); err != nil {
+		t.Errorf("Unmarshal: %+v", err)
+		return
+	}
+	if want := []Item{{"a", 2}, {"b", 5}, {"c", 7}}; !reflect.DeepEqual(items, want) {
+		t.Errorf("got %+v, want %+v", items, want)
+	}
+}
+
+func TestDecoderCoerceArrays(t *testing.T) {
+	type Params struct {
+		Tags  []string        `json:"tags"`
+		IDs   []int           `json:"ids"`
+		Q     string          `json:"q"`
+		Page  int             `json:"page"`
+		Multi string          `json:"multi"`
+		PTags *[]string       `json:"ptags"`
+		PQ    *string         `json:"pq"`
+		Raw   json.RawMessage `json:"raw"`
+	}
+	pq := "x"
+	testCases := []struct {
+		desc    string
+		body    string
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc: "scalar to slice",
+			body: `{"tags": "a", "ids": 1}`,
+			want: Params{Tags: []string{"a"}, IDs: []int{1}},
+		},
+		{
+			desc: "one-element array to scalar",
+			body: `{"q": ["golang"], "page": [2]}`,
+			want: Params{Q: "golang", Page: 2},
+		},
+		{
+			desc: "as is",
+			body: `{"tags": ["a", "b"], "q": "golang"}`,
+			want: Params{Tags: []string{"a", "b"}, Q: "golang"},
+		},
+		{
+			desc:    "multi-element array to scalar",
+			body:    `{"multi": ["a", "b"]}`,
+			wantErr: true,
+		},
+		{
+			desc: "pointers",
+			body: `{"ptags": ["a"], "pq": ["x"]}`,
+			want: Params{PTags: &[]string{"a"}, PQ: &pq},
+		},
+		{
+			desc: "scalar to pointer slice",
+			body: `{"ptags": "a"}`,
+			want: Params{PTags: &[]string{"a"}},
+		},
+		{
+			desc: "unmarshaler as is",
+			body: `{"raw": ["a"]}`,
+			want: Params{Raw: json.RawMessage(`["a"]`)},
+		},
+	}
+	d := reqconv.Decoder{CoerceArrays: true}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			var params Params
+			if err := d.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want err %t", c.body, err, c.wantErr)
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", c.body, params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderOverrideContentTypeHeader(t *testing.T) {
+	type Params struct {
+		Q string `json:"q"`
+	}
+	testCases := []struct {
+		desc     string
+		override string
+		want     Params
+		wantErr  bool
+	}{
+		{
+			desc:     "override to json",
+			override: "application/json; charset=utf-8",
+			want:     Params{Q: "golang"},
+		},
+		{
+			desc:    "no override",
+			wantErr: true,
+		},
+	}
+	d := reqconv.Decoder{OverrideContentTypeHeader: "X-Original-Content-Type"}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(`{"q": "golang"}`))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			if c.override != "" {
+				req.Header.Set("X-Original-Content-Type", c.override)
+			}
+			var params Params
+			if err := d.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal err = %v, want err %t", err, c.wantErr)
+			}
+			if params != c.want {
+				t.Errorf("Unmarshal = %+v, want %+v", params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONTimeFormat(t *testing.T) {
+	type Params struct {
+		Due     time.Time `json:"due" time_format:"02/01/2006"`
+		Local   time.Time `json:"local" time_format:"2006-01-02 15:04" time_location:"Asia/Shanghai"`
+		Created time.Time `json:"created"`
+	}
+	testCases := []struct {
+		desc    string
+		body    string
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc: "custom format",
+			body: `{"due": "25/12/2023", "created": "2023-06-01T00:00:00Z"}`,
+			want: Params{
+				Due:     time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC),
+				Created: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			desc: "custom format in location",
+			body: `{"local": "2023-06-01 08:00"}`,
+			want: Params{Local: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			desc:    "mismatched format",
+			body:    `{"due": "2023-12-25"}`,
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			var params Params
+			if err := reqconv.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.body, err, c.wantErr)
+				return
+			}
+			if c.wantErr {
+				return
+			}
+			if !params.Due.Equal(c.want.Due) || !params.Local.Equal(c.want.Local) || !params.Created.Equal(c.want.Created) {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.body, params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderBodyTransform(t *testing.T) {
+	type Params struct {
+		Q    string `json:"q"`
+		Page int    `json:"page"`
+	}
+	d := reqconv.Decoder{BodyTransform: func(b []byte) ([]byte, error) {
+		return base64.StdEncoding.DecodeString(string(b))
+	}}
+	testCases := []struct {
+		desc    string
+		body    string
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc: "base64 JSON",
+			body: base64.StdEncoding.EncodeToString([]byte(`{"q": "golang", "page": 2}`)),
+			want: Params{Q: "golang", Page: 2},
+		},
+		{
+			desc:    "transform error",
+			body:    `{"q": "golang"}`,
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			var params Params
+			if err := d.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.body, err, c.wantErr)
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.body, params, c.want)
+			}
+			// The body is restored as is.
+			if b, _ := ioutil.ReadAll(req.Body); string(b) != c.body {
+				t.Errorf("body got %s, want %s", b, c.body)
+			}
+		})
+	}
+}
+
+func TestDecoderFallbacks(t *testing.T) {
+	type Params struct {
+		Q    string `json:"q"`
+		Page int    `json:"page"`
+	}
+	testCases := []struct {
+		desc      string
+		fallbacks []string
+		body      string
+		want      Params
+		wantErr   bool
+	}{
+		{
+			desc: "declared",
+			body: `{"q": "golang", "page": 2}`,
+			want: Params{Q: "golang", Page: 2},
+		},
+		{
+			desc:      "mislabeled form",
+			fallbacks: []string{"application/x-www-form-urlencoded"},
+			body:      "q=golang&page=2",
+			want:      Params{Q: "golang", Page: 2},
+		},
+		{
+			desc:    "no fallback",
+			body:    "q=golang&page=2",
+			wantErr: true,
+		},
+		{
+			desc:      "all fail",
+			fallbacks: []string{"application/xml", "application/x-www-form-urlencoded"},
+			body:      "q=golang&page=x",
+			wantErr:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			d := reqconv.Decoder{Fallbacks: c.fallbacks}
+			var params Params
+			err = d.Unmarshal(req, &params)
+			if (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.body, err, c.wantErr)
+				return
+			}
+			if c.wantErr {
+				// The error of the declared media type.
+				if !strings.Contains(err.Error(), "application/json") {
+					t.Errorf("Unmarshal(%s) err = %v, want error of application/json", c.body, err)
+				}
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.body, params, c.want)
+			}
+			if got := req.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type got %s, want restored", got)
+			}
+		})
+	}
+}
+
+func TestDecoderNumbersAsStrings(t *testing.T) {
+	type Params struct {
+		ID   string   `json:"id"`
+		Refs []string `json:"refs"`
+		Page int      `json:"page"`
+	}
+	testCases := []struct {
+		desc    string
+		decoder reqconv.Decoder
+		body    string
+		want    Params
+		wantErr bool
+	}{
+		{
+			desc:    "number to string",
+			decoder: reqconv.Decoder{NumbersAsStrings: true},
+			body:    `{"id": 9007199254740993, "refs": [1, "a", -2.5e3], "page": 2}`,
+			want:    Params{ID: "9007199254740993", Refs: []string{"1", "a", "-2.5e3"}, Page: 2},
+		},
+		{
+			desc:    "string as is",
+			decoder: reqconv.Decoder{NumbersAsStrings: true},
+			body:    `{"id": "42"}`,
+			want:    Params{ID: "42"},
+		},
+		{
+			desc:    "with coerced arrays",
+			decoder: reqconv.Decoder{NumbersAsStrings: true, CoerceArrays: true},
+			body:    `{"id": [7], "refs": 5}`,
+			want:    Params{ID: "7", Refs: []string{"5"}},
+		},
+		{
+			desc:    "disabled",
+			body:    `{"id": 42}`,
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			var params Params
+			if err := c.decoder.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.body, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(params, c.want) {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.body, params, c.want)
+			}
+		})
+	}
+}
+
+func TestDecoderFormat(t *testing.T) {
+	type Params struct {
+		Q    string `json:"q" xml:"q"`
+		Page int    `json:"page" xml:"page"`
+	}
+	d := reqconv.Decoder{FormatQuery: "format", FormatSuffix: true}
+	testCases := []struct {
+		desc        string
+		url         string
+		contentType string
+		body        string
+		want        Params
+		wantErr     bool
+	}{
+		{
+			desc:        "xml by query",
+			url:         "http://google.com/search?format=xml",
+			contentType: "text/plain",
+			body:        `<params><q>golang</q><page>2</page></params>`,
+			want:        Params{Q: "golang", Page: 2},
+		},
+		{
+			desc: "json by suffix",
+			url:  "http://google.com/search.json",
+			body: `{"q": "golang", "page": 2}`,
+			want: Params{Q: "golang", Page: 2},
+		},
+		{
+			desc:        "form by query over suffix",
+			url:         "http://google.com/search.json?format=form",
+			contentType: "application/json",
+			body:        "q=golang&page=2",
+			want:        Params{Q: "golang", Page: 2},
+		},
+		{
+			desc:        "unknown suffix",
+			url:         "http://google.com/report.pdf",
+			contentType: "application/json",
+			body:        `{"q": "golang"}`,
+			want:        Params{Q: "golang"},
+		},
+		{
+			desc:        "multipart by query",
+			url:         "http://google.com/search?format=multipart",
+			contentType: "multipart/form-data; boundary=xyz",
+			body:        "--xyz\r\nContent-Disposition: form-data; name=\"q\"\r\n\r\ngolang\r\n--xyz\r\nContent-Disposition: form-data; name=\"page\"\r\n\r\n2\r\n--xyz--\r\n",
+			want:        Params{Q: "golang", Page: 2},
+		},
+		{
+			desc:        "registered yaml by query",
+			url:         "http://google.com/search?format=yaml",
+			contentType: "text/plain",
+			body:        "q: golang\npage: 2",
+			want:        Params{Q: "golang", Page: 2},
+		},
+		{
+			desc: "registered yaml by suffix",
+			url:  "http://google.com/search.yaml",
+			body: "q: golang\npage: 2",
+			want: Params{Q: "golang", Page: 2},
+		},
+		{
+			desc:    "unknown format",
+			url:     "http://google.com/search?format=toml",
+			body:    "q = 'golang'",
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, c.url, strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			if c.contentType != "" {
+				req.Header.Set("Content-Type", c.contentType)
+			}
+			var params Params
+			if err := d.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.url, err, c.wantErr)
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.url, params, c.want)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	// A toy format of the lines of key: value.
+	reqconv.Register("application/x-toy", func(r *http.Request, ptr interface{}) error {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		m := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			kv := strings.SplitN(line, ": ", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid line %q", line)
+			}
+			m[kv[0]] = kv[1]
+		}
+		b, err = json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, ptr)
+	})
+	type Params struct {
+		Q    string `json:"q"`
+		Lang string `json:"lang"`
+	}
+	testCases := []struct {
+		desc    string
+		body    string
+		want    Params
+		wantErr bool
+	}{
+		{desc: "registered", body: "q: golang\nlang: en", want: Params{Q: "golang", Lang: "en"}},
+		{desc: "registered error", body: "golang", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/x-toy; charset=utf-8")
+			var params Params
+			if err := reqconv.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.body, err, c.wantErr)
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.body, params, c.want)
+			}
+		})
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader("q=golang"))
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/csv")
+	var unsupported *reqconv.UnsupportedMediaTypeError
+	if err := reqconv.Unmarshal(req, &struct{}{}); !errors.As(err, &unsupported) {
+		t.Errorf("Unmarshal err = %v, want UnsupportedMediaTypeError", err)
+		return
+	}
+	if supported := unsupported.Supported(); !contains(supported, "application/x-toy") || !contains(supported, "application/json") {
+		t.Errorf("Supported() = %v, want the built-in and registered ones", supported)
+	}
+}
+
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUnmarshalStructuredSyntaxSuffix(t *testing.T) {
+	type Params struct {
+		Q    string `json:"q" xml:"q"`
+		Page int    `json:"page" xml:"page"`
+	}
+	testCases := []struct {
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{contentType: "application/vnd.api+json", body: `{"q": "golang", "page": 2}`},
+		{contentType: "application/json", body: `{"q": "golang", "page": 2}`},
+		{contentType: "application/atom+xml", body: `<feed><q>golang</q><page>2</page></feed>`},
+		{contentType: "application/soap+xml; charset=utf-8", body: `<envelope><q>golang</q><page>2</page></envelope>`},
+		{contentType: "application/vnd.api+yaml", body: "q: golang", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.contentType, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			var params Params
+			if err := reqconv.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.contentType, err, c.wantErr)
+				return
+			}
+			if want := (Params{Q: "golang", Page: 2}); !c.wantErr && params != want {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.contentType, params, want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalFramedJSON(t *testing.T) {
+	type Event struct {
+		Type string `json:"type"`
+		Seq  int    `json:"seq"`
+	}
+	frame := func(s string) string {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(len(s)))
+		return string(b) + s
+	}
+	testCases := []struct {
+		desc    string
+		body    string
+		ptr     interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			desc: "two frames",
+			body: frame(`{"type": "open", "seq": 1}`) + frame(`{"type": "close", "seq": 2}`),
+			ptr:  new([]Event),
+			want: &[]Event{{Type: "open", Seq: 1}, {Type: "close", Seq: 2}},
+		},
+		{
+			desc:    "truncated frame",
+			body:    frame(`{"type": "open"}`)[:10],
+			ptr:     new([]Event),
+			wantErr: true,
+		},
+		{
+			desc:    "truncated length",
+			body:    frame(`{"type": "open"}`) + "\x00\x00",
+			ptr:     new([]Event),
+			wantErr: true,
+		},
+		{
+			desc:    "non-slice target",
+			body:    frame(`{"type": "open"}`),
+			ptr:     new(Event),
+			wantErr: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", reqconv.FramedJSON)
+			if err := reqconv.Unmarshal(req, c.ptr); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal err = %v, want error %t", err, c.wantErr)
+				return
+			}
+			if !c.wantErr && !reflect.DeepEqual(c.ptr, c.want) {
+				t.Errorf("Unmarshal got %+v, want %+v", c.ptr, c.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalCharset(t *testing.T) {
+	type Params struct {
+		Q string `json:"q"`
+	}
+	testCases := []struct {
+		desc        string
+		contentType string
+		body        string
+		want        Params
+		wantErr     bool
+	}{
+		{
+			desc:        "gbk json",
+			contentType: "application/json; charset=gbk",
+			body:        "{\"q\": \"\xc4\xe3\xba\xc3\"}", // 你好
+			want:        Params{Q: "你好"},
+		},
+		{
+			desc:        "shift_jis form",
+			contentType: "application/x-www-form-urlencoded; charset=Shift_JIS",
+			body:        "q=%82%B1%82%F1%82%C9%82%BF%82%CD", // こんにちは
+			want:        Params{Q: "こんにちは"},
+		},
+		{
+			desc:        "shift_jis form unescaped",
+			contentType: "application/x-www-form-urlencoded; charset=Shift_JIS",
+			body:        "q=\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd", // こんにちは
+			want:        Params{Q: "こんにちは"},
+		},
+		{
+			desc:        "utf-8 as is",
+			contentType: "application/json; charset=UTF-8",
+			body:        `{"q": "你好"}`,
+			want:        Params{Q: "你好"},
+		},
+		{
+			desc:        "unknown charset",
+			contentType: "application/json; charset=x-klingon",
+			body:        `{"q": "golang"}`,
+			wantErr:     true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			var params Params
+			err = reqconv.Unmarshal(req, &params)
+			if (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.contentType, err, c.wantErr)
+				return
+			}
+			if c.wantErr {
+				if !strings.Contains(err.Error(), "x-klingon") {
+					t.Errorf("Unmarshal(%s) err = %v, want error of the charset", c.contentType, err)
+				}
+				return
+			}
+			if params != c.want {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.contentType, params, c.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalContentEncoding(t *testing.T) {
+	type Params struct {
+		Q    string `json:"q"`
+		Page int    `json:"page"`
+	}
+	const body = `{"q": "golang", "page": 2}`
+	compress := func(w io.WriteCloser, buf *bytes.Buffer) string {
+		w.Write([]byte(body))
+		w.Close()
+		return buf.String()
+	}
+	var gz, zl, fl bytes.Buffer
+	fw, _ := flate.NewWriter(&fl, flate.DefaultCompression)
+	testCases := []struct {
+		encoding string
+		body     string
+		wantErr  bool
+	}{
+		{encoding: "", body: body},
+		{encoding: "identity", body: body},
+		{encoding: "gzip", body: compress(gzip.NewWriter(&gz), &gz)},
+		{encoding: "deflate", body: compress(zlib.NewWriter(&zl), &zl)},
+		{encoding: "deflate", body: compress(fw, &fl)},
+		{encoding: "gzip", body: body, wantErr: true},
+		{encoding: "br", body: body, wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.encoding, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", c.encoding)
+			var params Params
+			if err := reqconv.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.encoding, err, c.wantErr)
+				return
+			}
+			if want := (Params{Q: "golang", Page: 2}); !c.wantErr && params != want {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.encoding, params, want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalCompressedForm(t *testing.T) {
+	gzipped := func(body string) string {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(body))
+		w.Close()
+		return buf.String()
+	}
+	var mp bytes.Buffer
+	mw := multipart.NewWriter(&mp)
+	mw.WriteField("q", "hi")
+	mw.Close()
+	testCases := []struct {
+		desc        string
+		contentType string
+		encoding    string
+		body        string
+		wantErr     bool
+	}{
+		{desc: "gzip form", contentType: "application/x-www-form-urlencoded", encoding: "gzip", body: gzipped("q=hi")},
+		{desc: "gzip multipart", contentType: mw.FormDataContentType(), encoding: "gzip", body: gzipped(mp.String())},
+		{desc: "gzip charset form", contentType: "application/x-www-form-urlencoded; charset=gbk", encoding: "gzip", body: gzipped("q=hi")},
+		{desc: "unsupported", contentType: "application/x-www-form-urlencoded", encoding: "br", body: "q=hi", wantErr: true},
+		{desc: "corrupted", contentType: "application/x-www-form-urlencoded", encoding: "gzip", body: "q=hi", wantErr: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			req.Header.Set("Content-Encoding", c.encoding)
+			var params struct {
+				Q string `json:"q"`
+			}
+			if err := reqconv.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.desc, err, c.wantErr)
+				return
+			}
+			if !c.wantErr && params.Q != "hi" {
+				t.Errorf("Unmarshal(%s) got %q, want hi", c.desc, params.Q)
+			}
+		})
+	}
+}
+
+func TestUnmarshalCompressedCharset(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("{\"q\": \"\xc4\xe3\xba\xc3\"}")) // 你好 in GBK
+	w.Close()
+	req, err := http.NewRequest(http.MethodPost, "http://google.com", &buf)
+	if err != nil {
+		t.Errorf("new request: %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json; charset=gbk")
+	req.Header.Set("Content-Encoding", "gzip")
+	var params struct {
+		Q string `json:"q"`
+	}
+	if err := reqconv.Unmarshal(req, &params); err != nil {
+		t.Errorf("Unmarshal err: %+v", err)
+		return
+	}
+	if want := "你好"; params.Q != want {
+		t.Errorf("Unmarshal got %s, want %s", params.Q, want)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	const body = `{"q": "golang"}`
+	var mp bytes.Buffer
+	mw := multipart.NewWriter(&mp)
+	mw.WriteField("q", strings.Repeat("x", len(body)))
+	mw.Close()
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(`{"q": "` + strings.Repeat("x", 1024) + `"}`))
+	gw.Close()
+	testCases := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        string
+		limit       int64
+		wantErr     bool
+	}{
+		{name: "unlimited", contentType: "application/json", body: body},
+		{name: "at limit", contentType: "application/json", body: body, limit: int64(len(body))},
+		{name: "one byte over", contentType: "application/json", body: body, limit: int64(len(body)) - 1, wantErr: true},
+		{name: "multipart", contentType: mw.FormDataContentType(), body: mp.String(), limit: int64(len(body)), wantErr: true},
+		{name: "decompressed", contentType: "application/json", encoding: "gzip", body: gz.String(), limit: int64(gz.Len()), wantErr: true},
+	}
+	defer func(limit int64) { reqconv.MaxBodyBytes = limit }(reqconv.MaxBodyBytes)
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			reqconv.MaxBodyBytes = c.limit
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			req.Header.Set("Content-Encoding", c.encoding)
+			var params struct {
+				Q string `json:"q"`
+			}
+			err = reqconv.Unmarshal(req, &params)
+			if got := errors.Is(err, reqconv.ErrBodyTooLarge); got != c.wantErr {
+				t.Errorf("Unmarshal() err = %v, want ErrBodyTooLarge %t", err, c.wantErr)
+				return
+			}
+			if !c.wantErr && params.Q != "golang" {
+				t.Errorf("Unmarshal() got %q, want %q", params.Q, "golang")
+			}
+		})
+	}
+}
+
+func TestDecoderStream(t *testing.T) {
+	type Item struct {
+		ID int `json:"id" xml:"id"`
+	}
+	type Params struct {
+		Q     string `json:"q" xml:"q"`
+		Items []Item `json:"items" xml:"items>item"`
+	}
+	want := Params{Q: "golang", Items: []Item{{ID: 1}, {ID: 2}}}
+	testCases := []struct {
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{contentType: "application/json", body: `{"q": "golang", "items": [{"id": 1}, {"id": 2}]}`},
+		{contentType: "application/json", body: `{"q": "golang"} {}`, wantErr: true},
+		{contentType: "application/json", body: `{"q": `, wantErr: true},
+		{contentType: "application/xml", body: `<params><q>golang</q><items><item><id>1</id></item><item><id>2</id></item></items></params>`},
+	}
+	d := reqconv.Decoder{Stream: true}
+	for _, c := range testCases {
+		t.Run(c.body, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			var params Params
+			if err := d.Unmarshal(req, &params); (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.body, err, c.wantErr)
+				return
+			}
+			if c.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(params, want) {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.body, params, want)
+			}
+			if b, _ := ioutil.ReadAll(req.Body); len(b) != 0 {
+				t.Errorf("Unmarshal(%s) body is restored as %s, want consumed", c.body, b)
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalJSON(b *testing.B) {
+	type Item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	items := make([]Item, 1000)
+	for i := range items {
+		items[i] = Item{ID: i, Name: fmt.Sprintf("item %d", i)}
+	}
+	body, err := json.Marshal(items)
+	if err != nil {
+		b.Fatalf("marshal: %+v", err)
+	}
+	for _, d := range []reqconv.Decoder{{}, {Stream: true}} {
+		name := "buffered"
+		if d.Stream {
+			name = "stream"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req, _ := http.NewRequest(http.MethodPost, "http://google.com", bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				var got []Item
+				if err := d.Unmarshal(req, &got); err != nil {
+					b.Fatalf("Unmarshal err: %+v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalNilBody(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch} {
+		t.Run(method, func(t *testing.T) {
+			req, err := http.NewRequest(method, "http://google.com?q=golang", nil)
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			var params struct {
+				Q string `json:"q"`
+			}
+			if err := reqconv.Unmarshal(req, &params); err != nil {
+				t.Errorf("%s Unmarshal err: %+v", method, err)
+				return
+			}
+			if params.Q != "golang" {
+				t.Errorf("%s Unmarshal got %q, want %q", method, params.Q, "golang")
+			}
+		})
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	type Params struct {
+		Q       string  `yaml:"q"`
+		Int     int     `yaml:"int"`
+		Float   float64 `yaml:"float"`
+		Bool    bool    `yaml:"bool"`
+		Array   []int   `yaml:"array"`
+		Default string  `yaml:"default"`
+	}
+	const body = `q: golang
+int: 233
+float: 3.14159
+bool: true
+array:
+  - 1
+  - 2
+  - 3
+`
+	want := Params{Q: "golang", Int: 233, Float: 3.14159, Bool: true, Array: []int{1, 2, 3}, Default: "2333"}
+	testCases := []struct {
+		url         string
+		contentType string
+		wantErr     bool
+	}{
+		{url: "http://google.com", contentType: "application/yaml"},
+		{url: "http://google.com", contentType: "text/yaml; charset=utf-8"},
+	}
+	for _, c := range testCases {
+		t.Run(c.contentType, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, c.url, strings.NewReader(body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			params := Params{Default: "2333"}
+			if err := reqconv.Unmarshal(req, &params); err != nil {
+				t.Errorf("Unmarshal(%s) err: %+v", c.contentType, err)
+				return
+			}
+			if !reflect.DeepEqual(params, want) {
+				t.Errorf("Unmarshal(%s) got %+v, want %+v", c.contentType, params, want)
+			}
+			if b, _ := ioutil.ReadAll(req.Body); string(b) != body {
+				t.Errorf("Unmarshal(%s) body is restored as %q, want %q", c.contentType, b, body)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "http://google.com", strings.NewReader("array: [1, x"))
+		if err != nil {
+			t.Errorf("new request: %+v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/yaml")
+		var params Params
+		if err := reqconv.Unmarshal(req, &params); err == nil {
+			t.Errorf("Unmarshal got %+v, want error", params)
+		}
+	})
+}
+
+type pageParams struct {
+	Page int `json:"page" xml:"page"`
+	Size int `json:"size" xml:"size"`
+}
+
+var errSizeOutOfRange = errors.New("size out of range")
+
+func (p *pageParams) Validate() error {
+	if p.Size < 1 || p.Size > 100 {
+		return errSizeOutOfRange
+	}
+	return nil
+}
+
+func TestUnmarshalValidator(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		skip        bool
+		wantErr     bool
+	}{
+		{desc: "json", method: http.MethodPost, contentType: "application/json", body: `{"page": 1, "size": 20}`},
+		{desc: "json out of range", method: http.MethodPost, contentType: "application/json", body: `{"page": 1, "size": 200}`, wantErr: true},
+		{desc: "xml out of range", method: http.MethodPost, contentType: "application/xml", body: `<p><size>0</size></p>`, wantErr: true},
+		{desc: "form out of range", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", body: `size=200`, wantErr: true},
+		{desc: "query out of range", method: http.MethodGet, url: "?size=200", wantErr: true},
+		{desc: "skip", method: http.MethodPost, contentType: "application/json", body: `{"size": 200}`, skip: true},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, "http://google.com"+c.url, strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			d := reqconv.Decoder{SkipValidate: c.skip}
+			var params pageParams
+			err = d.Unmarshal(req, &params)
+			if (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal(%s) err = %v, want error %t", c.body, err, c.wantErr)
+				return
+			}
+			if c.wantErr && err != errSizeOutOfRange {
+				t.Errorf("Unmarshal(%s) err = %v, want %v", c.body, err, errSizeOutOfRange)
+			}
+		})
+	}
+}
+
+func TestUnmarshalOnBindAfterValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		wantErr     bool
+		wantBound   bool
+	}{
+		{desc: "query", method: http.MethodGet, url: "?size=20", wantBound: true},
+		{desc: "query invalid", method: http.MethodGet, url: "?size=200", wantErr: true},
+		{desc: "form", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", body: "size=20", wantBound: true},
+		{desc: "form invalid", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", body: "size=200", wantErr: true},
+		{desc: "json", method: http.MethodPost, contentType: "application/json", body: `{"size": 20}`},
+	}
+	for _, c := range testCases {
+		t.Run(c.desc, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, "http://google.com"+c.url, strings.NewReader(c.body))
+			if err != nil {
+				t.Errorf("new request: %+v", err)
+				return
+			}
+			req.Header.Set("Content-Type", c.contentType)
+			var bound []pageParams
+			d := reqconv.Decoder{Form: &form.Decoder{OnBind: func(r *http.Request, ptr interface{}) {
+				bound = append(bound, *ptr.(*pageParams))
+			}}}
+			var params pageParams
+			err = d.Unmarshal(req, &params)
+			if (err != nil) != c.wantErr {
+				t.Errorf("Unmarshal err = %v, want error %t", err, c.wantErr)
+			}
+			if got := len(bound) == 1; got != c.wantBound {
+				t.Errorf("Unmarshal OnBind got %+v, want called %t", bound, c.wantBound)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package reqconv
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// decompressBody replaces the body of r of the Content-Encoding with the decompressed one,
+// e.g., for the form bodies parsed by the form package which is unaware of the encoding.
+func decompressBody(r *http.Request) error {
+	encoding := strings.TrimSpace(r.Header.Get("Content-Encoding"))
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if b, err = decompress(b, encoding); err != nil {
+		return err
+	}
+	// The body is no longer encoded once replaced.
+	r.Header.Del("Content-Encoding")
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	return nil
+}
+
+// decompress decodes the body b of the Content-Encoding, i.e., gzip, deflate or identity.
+func decompress(b []byte, encoding string) ([]byte, error) {
+	var r io.ReadCloser
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return b, nil
+	case "gzip", "x-gzip":
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("decompress gzip body: %v", err)
+		}
+	case "deflate":
+		// The deflate of HTTP is the zlib format, however, some clients send the raw deflate.
+		if isZlib(b) {
+			var err error
+			if r, err = zlib.NewReader(bytes.NewReader(b)); err != nil {
+				return nil, fmt.Errorf("decompress deflate body: %v", err)
+			}
+		} else {
+			r = flate.NewReader(bytes.NewReader(b))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	defer r.Close()
+	var lr io.Reader = r
+	if MaxBodyBytes > 0 {
+		// Guard against the decompression bombs, a tiny body inflated to gigabytes.
+		lr = io.LimitReader(r, MaxBodyBytes+1)
+	}
+	b, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s body: %v", encoding, err)
+	}
+	if MaxBodyBytes > 0 && int64(len(b)) > MaxBodyBytes {
+		return nil, fmt.Errorf("decompress %s body: %w", encoding, ErrBodyTooLarge)
+	}
+	return b, nil
+}
+
+// isZlib reports whether b starts with a zlib header of the deflate method, see RFC 1950.
+func isZlib(b []byte) bool {
+	return len(b) >= 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
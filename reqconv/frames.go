@@ -0,0 +1,44 @@
+package reqconv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// FramedJSON is the media type of the length-prefixed JSON frames,
+// i.e., each frame is a 4-byte big-endian length followed by a JSON value of that many bytes.
+// It decodes into a slice target, one element per frame.
+const FramedJSON = "application/x-framed-json"
+
+func init() {
+	registry[FramedJSON] = func(d *Decoder, r *http.Request, ptr interface{}) error {
+		return d.unmarshal(r, ptr, d.unmarshalFrames)
+	}
+}
+
+// unmarshalFrames appends the JSON frames in b to the slice pointed to by ptr.
+func (d *Decoder) unmarshalFrames(b []byte, ptr interface{}) error {
+	v := reflect.ValueOf(ptr).Elem()
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("framed JSON requires a slice target, got %T", ptr)
+	}
+	for i := 0; len(b) > 0; i++ {
+		if len(b) < 4 {
+			return fmt.Errorf("frame %d: truncated length", i)
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint64(len(b)) < uint64(n) {
+			return fmt.Errorf("frame %d: truncated, want %d bytes, got %d", i, n, len(b))
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := d.decodeJSON(b[:n], elem.Interface()); err != nil {
+			return fmt.Errorf("frame %d: %v", i, err)
+		}
+		v.Set(reflect.Append(v, elem.Elem()))
+		b = b[n:]
+	}
+	return nil
+}
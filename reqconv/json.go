@@ -0,0 +1,188 @@
+package reqconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unmarshalJSONPaths populates the fields of the struct pointed to by ptr
+// tagged with a dotted path, e.g., `json:"data.totals.grand"`, from the JSON object b.
+// A path not found in b leaves the field untouched.
+func unmarshalJSONPaths(b []byte, ptr interface{}, unmarshaler func(b []byte, ptr interface{}) error) error {
+	v := reflect.ValueOf(ptr).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if !strings.Contains(name, ".") {
+			continue
+		}
+		raw, ok := lookupJSONPath(b, strings.Split(name, "."))
+		if !ok {
+			continue
+		}
+		if err := unmarshaler(raw, v.Field(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupJSONPath returns the raw value at the path of object keys in the JSON value b.
+func lookupJSONPath(b []byte, path []string) (json.RawMessage, bool) {
+	raw := json.RawMessage(b)
+	for _, key := range path {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, false
+		}
+		var ok bool
+		if raw, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return raw, true
+}
+
+// coerceJSONArrays rewrites the top-level members of the JSON object b to match the struct pointed to by ptr,
+// a single value for a slice field is wrapped as a one-element array,
+// and a one-element array for a scalar field is unwrapped.
+func coerceJSONArrays(b []byte, ptr interface{}) ([]byte, error) {
+	v := reflect.Indirect(reflect.ValueOf(ptr))
+	if v.Kind() != reflect.Struct {
+		return b, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return b, nil // Not an object, leave it to the decoder.
+	}
+	for key, raw := range obj {
+		f := jsonField(v, key)
+		if !f.IsValid() || isNull(raw) {
+			continue
+		}
+		t := f.Type()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+			continue // Unmarshaled by itself, e.g., a single value or an array.
+		}
+		isArray := bytes.HasPrefix(bytes.TrimSpace(raw), []byte("["))
+		switch {
+		case t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8: // []byte is a base64 string
+			if !isArray {
+				obj[key] = append(append(json.RawMessage("["), raw...), ']')
+			}
+		case t.Kind() != reflect.Array && t.Kind() != reflect.Interface:
+			var elems []json.RawMessage
+			if isArray && json.Unmarshal(raw, &elems) == nil && len(elems) == 1 {
+				obj[key] = elems[0]
+			}
+		}
+	}
+	return json.Marshal(obj)
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// formatJSONTimes rewrites the top-level string members of the JSON object b destined for the time.Time fields
+// tagged with a layout, e.g., `time_format:"02/01/2006"`, into RFC 3339 which encoding/json parses.
+// A time without zone is interpreted in the zone of the `time_location` tag or UTC like the form.
+func formatJSONTimes(b []byte, ptr interface{}) ([]byte, error) {
+	v := reflect.Indirect(reflect.ValueOf(ptr))
+	if v.Kind() != reflect.Struct || !hasTimeFormat(v.Type()) {
+		return b, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return b, nil // Not an object, leave it to the decoder.
+	}
+	for key, raw := range obj {
+		fieldInfo, ok := jsonStructField(v.Type(), key)
+		if !ok || fieldInfo.Type != timeType || fieldInfo.Tag.Get("time_format") == "" {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			continue // Not a string, leave it to the decoder.
+		}
+		loc := time.UTC
+		if name := fieldInfo.Tag.Get("time_location"); name != "" {
+			var err error
+			if loc, err = time.LoadLocation(name); err != nil {
+				return nil, fmt.Errorf("%s: invalid time location %q", key, name)
+			}
+		}
+		t, err := time.ParseInLocation(fieldInfo.Tag.Get("time_format"), value, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		if obj[key], err = json.Marshal(t); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return json.Marshal(obj)
+}
+
+func hasTimeFormat(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type == timeType && t.Field(i).Tag.Get("time_format") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// stringifyJSONNumbers rewrites the top-level number members of the JSON object b destined for the string fields
+// of the struct pointed to by ptr, or the elements of the []string fields, into JSON strings of the number as is.
+func stringifyJSONNumbers(b []byte, ptr interface{}) ([]byte, error) {
+	v := reflect.Indirect(reflect.ValueOf(ptr))
+	if v.Kind() != reflect.Struct {
+		return b, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return b, nil // Not an object, leave it to the decoder.
+	}
+	for key, raw := range obj {
+		f := jsonField(v, key)
+		if !f.IsValid() {
+			continue
+		}
+		switch {
+		case f.Kind() == reflect.String:
+			obj[key] = stringifyJSONNumber(raw)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+			var elems []json.RawMessage
+			if json.Unmarshal(raw, &elems) != nil {
+				continue
+			}
+			for i := range elems {
+				elems[i] = stringifyJSONNumber(elems[i])
+			}
+			var err error
+			if obj[key], err = json.Marshal(elems); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(obj)
+}
+
+// stringifyJSONNumber quotes raw if it's a JSON number, the digits are kept as is without the float64 rounding.
+func stringifyJSONNumber(raw json.RawMessage) json.RawMessage {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || (raw[0] != '-' && (raw[0] < '0' || raw[0] > '9')) {
+		return raw
+	}
+	return json.RawMessage(strconv.Quote(string(raw)))
+}
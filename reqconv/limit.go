@@ -0,0 +1,41 @@
+package reqconv
+
+import (
+	"errors"
+	"io"
+)
+
+// MaxBodyBytes limits the bytes of the request body read for unmarshaling, 0 means unlimited.
+// It covers the multipart body as a whole, i.e., the file parts beyond form.MultipartMaxMemory stored on disk,
+// and the decompressed body of the Content-Encoding as well.
+var MaxBodyBytes int64
+
+// ErrBodyTooLarge is returned when the request body exceeds MaxBodyBytes, e.g., for a 413 response.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// maxBodyReader reads up to n bytes of r, it records the excess rather than truncates silently.
+type maxBodyReader struct {
+	r        io.ReadCloser
+	n        int64
+	exceeded bool
+}
+
+func (l *maxBodyReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		l.exceeded = true
+		return int(l.n), ErrBodyTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+func (l *maxBodyReader) Close() error {
+	return l.r.Close()
+}
@@ -0,0 +1,130 @@
+package reqconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// mergePatch applies the JSON merge patch b onto the value pointed to by ptr,
+// see https://tools.ietf.org/html/rfc7386 for more information.
+func mergePatch(b []byte, ptr interface{}) error {
+	return applyMergePatch(reflect.ValueOf(ptr).Elem(), b)
+}
+
+func applyMergePatch(v reflect.Value, patch json.RawMessage) error {
+	patch = bytes.TrimSpace(patch)
+	if len(patch) == 0 || patch[0] != '{' {
+		// Not an object, replace the target entirely, null means delete.
+		return replaceValue(v, patch)
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &obj); err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return applyMergePatch(v.Elem(), patch)
+	case reflect.Struct:
+		for key, raw := range obj {
+			f := jsonField(v, key)
+			if !f.IsValid() {
+				continue // ignore unknown members like encoding/json
+			}
+			if isNull(raw) {
+				f.Set(reflect.Zero(f.Type()))
+				continue
+			}
+			if err := applyMergePatch(f, raw); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for key, raw := range obj {
+			k := reflect.ValueOf(key).Convert(v.Type().Key())
+			if isNull(raw) {
+				v.SetMapIndex(k, reflect.Value{})
+				continue
+			}
+			// Map elements are not addressable, patch a copy then put it back.
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if old := v.MapIndex(k); old.IsValid() {
+				elem.Set(old)
+			}
+			if err := applyMergePatch(elem, raw); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return replaceValue(v, patch)
+		}
+		// The object patches a generic object, e.g., of a map[string]interface{},
+		// which is a fresh one if the target is not an object.
+		m, ok := v.Interface().(map[string]interface{})
+		if !ok {
+			m = make(map[string]interface{}, len(obj))
+		}
+		if err := applyMergePatch(reflect.ValueOf(&m).Elem(), patch); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return replaceValue(v, patch)
+	}
+	return nil
+}
+
+// replaceValue replaces v with the patch entirely.
+func replaceValue(v reflect.Value, patch json.RawMessage) error {
+	v.Set(reflect.Zero(v.Type()))
+	return json.Unmarshal(patch, v.Addr().Interface())
+}
+
+// jsonField returns the field of struct v for the JSON object key like encoding/json,
+// preferring an exact match but also accepting a case-insensitive match.
+func jsonField(v reflect.Value, key string) reflect.Value {
+	if fieldInfo, ok := jsonStructField(v.Type(), key); ok {
+		return v.FieldByIndex(fieldInfo.Index)
+	}
+	return reflect.Value{}
+}
+
+// jsonStructField is like jsonField but returns the struct field of the struct type t.
+func jsonStructField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var fold reflect.StructField
+	var folded bool
+	for i := 0; i < t.NumField(); i++ {
+		fieldInfo := t.Field(i)
+		if fieldInfo.PkgPath != "" {
+			continue // unexported
+		}
+		name := strings.Split(fieldInfo.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = fieldInfo.Name
+		}
+		if name == key {
+			return fieldInfo, true
+		}
+		if !folded && strings.EqualFold(name, key) {
+			fold, folded = fieldInfo, true
+		}
+	}
+	return fold, folded
+}
+
+func isNull(raw json.RawMessage) bool {
+	return string(bytes.TrimSpace(raw)) == "null"
+}
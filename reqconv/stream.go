@@ -0,0 +1,51 @@
+package reqconv
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// streamable reports whether the body of r can be decoded as it's read,
+// i.e., none of the features which need the whole body is in effect.
+func (d *Decoder) streamable(r *http.Request) bool {
+	if !d.Stream || d.BodyTransform != nil || len(d.Fallbacks) > 0 {
+		return false
+	}
+	if encoding := strings.TrimSpace(r.Header.Get("Content-Encoding")); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	return true
+}
+
+// streamJSON decodes the JSON body of r into ptr as it's read, unless the rewrites of the whole body are required.
+func (d *Decoder) streamJSON(r *http.Request, ptr interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(ptr))
+	if d.NumbersAsStrings || d.CoerceArrays || d.JSONPaths || (v.Kind() == reflect.Struct && hasTimeFormat(v.Type())) {
+		return d.unmarshal(r, ptr, d.unmarshalJSON)
+	}
+	dec := json.NewDecoder(r.Body)
+	if d.UseNumber {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(ptr); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// streamXML decodes the XML body of r into ptr as it's read.
+func (d *Decoder) streamXML(r *http.Request, ptr interface{}) error {
+	v := reflect.ValueOf(ptr).Elem()
+	if d.XMLElement == "" || v.Kind() != reflect.Slice {
+		return xml.NewDecoder(r.Body).Decode(ptr)
+	}
+	return unmarshalXMLElements(r.Body, v, d.XMLElement)
+}
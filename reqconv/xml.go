@@ -0,0 +1,40 @@
+package reqconv
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+)
+
+func (d *Decoder) unmarshalXML(b []byte, ptr interface{}) error {
+	v := reflect.ValueOf(ptr).Elem()
+	if d.XMLElement == "" || v.Kind() != reflect.Slice {
+		return xml.Unmarshal(b, ptr)
+	}
+	return unmarshalXMLElements(bytes.NewReader(b), v, d.XMLElement)
+}
+
+// unmarshalXMLElements appends the XML elements of the name read from r to the slice v,
+// wherever they are nested.
+func unmarshalXMLElements(r io.Reader, v reflect.Value, name string) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != name {
+			continue
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := dec.DecodeElement(elem.Interface(), &start); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, elem.Elem()))
+	}
+}
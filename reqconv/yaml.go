@@ -0,0 +1,16 @@
+package reqconv
+
+import (
+	"net/http"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	// Both are in use, application/yaml is registered by RFC 9512.
+	for _, mediaType := range []string{"application/yaml", "text/yaml"} {
+		registry[mediaType] = func(d *Decoder, r *http.Request, ptr interface{}) error {
+			return d.unmarshal(r, ptr, yaml.Unmarshal)
+		}
+	}
+}